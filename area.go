@@ -0,0 +1,60 @@
+package main
+
+// fillArea converts an area given as the rings of a closed way or a
+// multipolygon.
+func fillArea(l *layers, r *rule, rings [][]point) {
+	if r.Industry != "" {
+		l.addSite(r, rings)
+	}
+	if r.Zone != "" {
+		fillPolygon(rings, func(x, y int) {
+			l.zones[xyToTile(x, y)] = tropicZones[r.Zone]
+		})
+	}
+	switch {
+	case r.isFields():
+		fillFields(l, r, rings)
+		return
+	case r.Class == 3:
+		fillBuilding(l, r, rings)
+		return
+	case r.Class == 8:
+		return
+	}
+	fillPolygon(rings, func(x, y int) {
+		l.set(r, x, y)
+	})
+}
+
+// coverageSamples is the number of samples per tile in each direction used to
+// estimate how much of a tile a building covers.
+const coverageSamples = 4
+
+// fillBuilding marks the tiles that a building covers enough as houses. If it
+// doesn't cover any tile enough, the tile it covers most is marked instead,
+// so that small houses don't disappear.
+func fillBuilding(l *layers, r *rule, rings [][]point) {
+	marked := false
+	bestX, bestY, bestCoverage := -1, -1, 0.0
+	polygonCoverage(rings, coverageSamples, func(x, y int, coverage float64) {
+		if coverage >= *buildingCoverage {
+			l.set(r, x, y)
+			marked = true
+		}
+		if coverage > bestCoverage {
+			bestX, bestY, bestCoverage = x, y, coverage
+		}
+	})
+	if marked {
+		return
+	}
+	if bestCoverage > 0 {
+		l.set(r, bestX, bestY)
+		return
+	}
+	// too small to hit any sample
+	c := centroid(rings[0])
+	if c.x >= 0 && c.y >= 0 && c.x < 256 && c.y < 256 {
+		l.set(r, int(c.x), int(c.y))
+	}
+}
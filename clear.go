@@ -0,0 +1,108 @@
+package main
+
+import "osm2ttd/ttd"
+
+const (
+	fieldTypes = 6 // the field types that look right in all climates
+	hedge      = 3
+	farmType   = 9 // in temperate and arctic
+)
+
+// isFields returns whether the rule is for fields.
+func (r *rule) isFields() bool {
+	return r.Class == 0 && r.Type>>2 == ttd.ClearFields
+}
+
+// fillFields fills an area with fields of the same type, which are told
+// apart from neighbouring areas for the hedges.
+func fillFields(l *layers, r *rule, rings [][]point) {
+	l.areas++
+	area := l.areas
+	fillPolygon(rings, func(x, y int) {
+		l.add(x, y, candidate{rule: r, area: area})
+	})
+}
+
+// finishFields plants hedges on the borders of the areas of fields and
+// gives the fields to the closest farm. TTD only has hedges on the
+// south-east and south-west edges of a field tile, so the other borders
+// only get one if there are other fields behind them.
+func (l *layers) finishFields(s *ttd.Savegame) {
+	isField := func(x, y, area int) bool {
+		if x > 255 || y > 255 {
+			return false
+		}
+		i := xyToTile(x, y)
+		t := s.Tiles[i]
+		return t.Class == 0 && t.Type>>2 == ttd.ClearFields && l.tiles[i].area == area
+	}
+	for i, c := range l.tiles {
+		x, y := i%256, i/256
+		if c.rule == nil || !c.rule.isFields() || !isField(x, y, c.area) {
+			continue
+		}
+		t := &s.Tiles[i]
+		if !isField(x, y+1, c.area) {
+			t.HedgeSE = hedge
+		}
+		if !isField(x+1, y, c.area) {
+			t.HedgeSW = hedge
+		}
+		t.Industry = ttd.NoIndustry
+		closest := -1
+		for j, ind := range s.Industries {
+			d := abs(int(ind.X)-x) + abs(int(ind.Y)-y)
+			if ind.Type == farmType && (closest < 0 || d < closest) {
+				t.Industry, closest = uint8(j), d
+			}
+		}
+	}
+}
+
+// setGround adjusts the ground of a clear tile to the climate: snow only
+// exists in the arctic and desert in the tropics, elsewhere they become
+// rough land. Desert tiles are also in the desert zone, or the game would
+// turn them back into grass.
+func setGround(s *ttd.Savegame, x, y int) {
+	t := &s.Tiles[xyToTile(x, y)]
+	switch ground := t.Type >> 2; {
+	case ground == ttd.ClearSnow && s.LandscapeType != 1,
+		ground == ttd.ClearDesert && s.LandscapeType != 2:
+		t.Type = ttd.ClearRough<<2 | 3
+	case ground == ttd.ClearDesert:
+		setTropicZone(s, x, y, ttd.TropicDesert)
+	}
+}
+
+// tropicZones are the zones that rules can give their areas.
+var tropicZones = map[string]uint8{
+	"desert":     ttd.TropicDesert,
+	"rainforest": ttd.TropicRainforest,
+}
+
+func tropicZone(s *ttd.Savegame, x, y int) uint8 {
+	if s.TropicZones == nil {
+		return ttd.TropicNormal
+	}
+	return s.TropicZones[xyToTile(x, y)]
+}
+
+// setZones puts the areas of the rules with a tropic zone in that zone, on
+// sub-tropical maps.
+func (l *layers) setZones(s *ttd.Savegame) {
+	if s.LandscapeType != 2 {
+		return
+	}
+	for i, zone := range l.zones {
+		if zone != ttd.TropicNormal {
+			setTropicZone(s, i%256, i/256, zone)
+		}
+	}
+}
+
+func setTropicZone(s *ttd.Savegame, x, y int, zone uint8) {
+	if s.TropicZones == nil {
+		s.TropicZones = make([]uint8, ttd.NumberOfTiles)
+	}
+	s.TropicZones[xyToTile(x, y)] = zone
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"osm2ttd/ttd"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// grid is a regular latitude/longitude grid of elevation samples in metres.
+// Samples without data are NaN.
+type grid struct {
+	width, height int
+	north, west   float64 // coordinates of the top left sample
+	dLat, dLon    float64 // distance between samples in degrees
+	data          []float32
+}
+
+func (g *grid) at(row, col int) float64 {
+	return float64(g.data[row*g.width+col])
+}
+
+// elevation returns the bilinearly interpolated elevation at the given
+// coordinates, or false if they are outside the grid or have no data.
+func (g *grid) elevation(lat, lon float64) (float64, bool) {
+	fr := (g.north - lat) / g.dLat
+	fc := (lon - g.west) / g.dLon
+	if fr < 0 || fc < 0 || fr > float64(g.height-1) || fc > float64(g.width-1) {
+		return 0, false
+	}
+	r := min(int(fr), g.height-2)
+	c := min(int(fc), g.width-2)
+	fr -= float64(r)
+	fc -= float64(c)
+	sum, weights := 0.0, 0.0
+	for _, s := range []struct {
+		row, col int
+		weight   float64
+	}{
+		{r, c, (1 - fr) * (1 - fc)},
+		{r, c + 1, (1 - fr) * fc},
+		{r + 1, c, fr * (1 - fc)},
+		{r + 1, c + 1, fr * fc},
+	} {
+		e := g.at(s.row, s.col)
+		if !math.IsNaN(e) && s.weight > 0 {
+			sum += e * s.weight
+			weights += s.weight
+		}
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return sum / weights, true
+}
+
+// readHGT reads an SRTM .hgt file. The location is taken from the file name,
+// e.g. N58E026.hgt covers latitudes 58-59 and longitudes 26-27.
+func readHGT(filename string) (*grid, error) {
+	name := strings.ToUpper(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	if len(name) != 7 || (name[0] != 'N' && name[0] != 'S') || (name[3] != 'E' && name[3] != 'W') {
+		return nil, fmt.Errorf("%s: can't parse location from file name", filename)
+	}
+	lat, err := strconv.Atoi(name[1:3])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	lon, err := strconv.Atoi(name[4:7])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if name[0] == 'S' {
+		lat = -lat
+	}
+	if name[3] == 'W' {
+		lon = -lon
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	n := int(math.Sqrt(float64(len(data) / 2)))
+	if n < 2 || n*n*2 != len(data) {
+		return nil, fmt.Errorf("%s: unexpected file size %d", filename, len(data))
+	}
+	g := &grid{
+		width:  n,
+		height: n,
+		north:  float64(lat + 1),
+		west:   float64(lon),
+		dLat:   1 / float64(n-1),
+		dLon:   1 / float64(n-1),
+		data:   make([]float32, n*n),
+	}
+	for i := range g.data {
+		v := int16(binary.BigEndian.Uint16(data[2*i:]))
+		if v == -32768 { // void
+			g.data[i] = float32(math.NaN())
+		} else {
+			g.data[i] = float32(v)
+		}
+	}
+	return g, nil
+}
+
+func loadDEM(filenames string) ([]*grid, error) {
+	var grids []*grid
+	for _, filename := range strings.Split(filenames, ",") {
+		var g *grid
+		var err error
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".hgt":
+			g, err = readHGT(filename)
+		case ".tif", ".tiff":
+			g, err = readGeoTIFF(filename)
+		default:
+			err = fmt.Errorf("%s: unknown elevation file type", filename)
+		}
+		if err != nil {
+			return nil, err
+		}
+		grids = append(grids, g)
+	}
+	return grids, nil
+}
+
+// elevationToHeight quantizes an elevation in metres to a TTD height level.
+// Elevations below the sea level get height 0, the rest start from 1.
+func elevationToHeight(e float64) uint8 {
+	h := 1 + math.Floor((e-*seaLevel)/(*verticalScale))
+	return uint8(max(0, min(15, h)))
+}
+
+// applyElevation sets the height of each tile from the first grid that has
+// data for the tile's north corner.
+func applyElevation(s *ttd.Savegame, grids []*grid) {
+	missing := 0
+	lowest, highest := uint8(15), uint8(0)
+	for y := range 256 {
+		for x := range 256 {
+			lat, lon := pointToCoord(point{float64(x), float64(y)})
+			found := false
+			for _, g := range grids {
+				if e, ok := g.elevation(lat, lon); ok {
+					h := elevationToHeight(e)
+					s.Tiles[xyToTile(x, y)].Height = h
+					lowest = min(lowest, h)
+					highest = max(highest, h)
+					found = true
+					break
+				}
+			}
+			if !found {
+				missing++
+			}
+		}
+	}
+	fmt.Printf("Elevation: heights %d-%d, %d tiles without data\n", lowest, highest, missing)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Only single band GeoTIFFs in geographic (latitude/longitude) coordinates
+// are supported, with no compression or deflate compression.
+
+const (
+	tiffImageWidth       = 256
+	tiffImageLength      = 257
+	tiffBitsPerSample    = 258
+	tiffCompression      = 259
+	tiffStripOffsets     = 273
+	tiffSamplesPerPixel  = 277
+	tiffRowsPerStrip     = 278
+	tiffStripByteCounts  = 279
+	tiffPredictor        = 317
+	tiffTileWidth        = 322
+	tiffTileLength       = 323
+	tiffTileOffsets      = 324
+	tiffTileByteCounts   = 325
+	tiffSampleFormat     = 339
+	geoModelPixelScale   = 33550
+	geoModelTiepoint     = 33922
+	geoModelTransform    = 34264
+	geoKeyDirectory      = 34735
+	gdalNoData           = 42113
+	geoKeyRasterType     = 1025
+	geoRasterPixelIsArea = 1
+)
+
+type tiffField struct {
+	typ uint16
+	n   int
+	raw []byte
+}
+
+func tiffTypeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7: // byte, ascii, sbyte, undefined
+		return 1
+	case 3, 8: // short, sshort
+		return 2
+	case 4, 9, 11: // long, slong, float
+		return 4
+	case 5, 10, 12: // rational, srational, double
+		return 8
+	}
+	return 0
+}
+
+func (f tiffField) ints(bo binary.ByteOrder) []int {
+	out := make([]int, f.n)
+	for i := range out {
+		switch f.typ {
+		case 1:
+			out[i] = int(f.raw[i])
+		case 3:
+			out[i] = int(bo.Uint16(f.raw[2*i:]))
+		case 4:
+			out[i] = int(bo.Uint32(f.raw[4*i:]))
+		}
+	}
+	return out
+}
+
+func (f tiffField) floats(bo binary.ByteOrder) []float64 {
+	out := make([]float64, f.n)
+	for i := range out {
+		switch f.typ {
+		case 11:
+			out[i] = float64(math.Float32frombits(bo.Uint32(f.raw[4*i:])))
+		case 12:
+			out[i] = math.Float64frombits(bo.Uint64(f.raw[8*i:]))
+		}
+	}
+	return out
+}
+
+func readTIFFFields(data []byte) (binary.ByteOrder, map[uint16]tiffField, error) {
+	if len(data) < 8 {
+		return nil, nil, fmt.Errorf("file too short")
+	}
+	var bo binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, nil, fmt.Errorf("not a TIFF file")
+	}
+	if bo.Uint16(data[2:]) != 42 {
+		return nil, nil, fmt.Errorf("unsupported TIFF version %d", bo.Uint16(data[2:]))
+	}
+	ifd := int(bo.Uint32(data[4:]))
+	if ifd+2 > len(data) {
+		return nil, nil, fmt.Errorf("IFD offset %d out of range", ifd)
+	}
+	count := int(bo.Uint16(data[ifd:]))
+	fields := make(map[uint16]tiffField)
+	for i := range count {
+		e := ifd + 2 + 12*i
+		if e+12 > len(data) {
+			return nil, nil, fmt.Errorf("IFD entry %d out of range", i)
+		}
+		f := tiffField{
+			typ: bo.Uint16(data[e+2:]),
+			n:   int(bo.Uint32(data[e+4:])),
+		}
+		size := f.n * tiffTypeSize(f.typ)
+		offset := e + 8
+		if size > 4 {
+			offset = int(bo.Uint32(data[e+8:]))
+		}
+		if offset+size > len(data) {
+			return nil, nil, fmt.Errorf("tag %d out of range", bo.Uint16(data[e:]))
+		}
+		f.raw = data[offset : offset+size]
+		fields[bo.Uint16(data[e:])] = f
+	}
+	return bo, fields, nil
+}
+
+func readGeoTIFF(filename string) (*grid, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	bo, fields, err := readTIFFFields(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	intField := func(tag uint16, def int) int {
+		if f, ok := fields[tag]; ok && f.n > 0 {
+			return f.ints(bo)[0]
+		}
+		return def
+	}
+
+	width := intField(tiffImageWidth, 0)
+	height := intField(tiffImageLength, 0)
+	bits := intField(tiffBitsPerSample, 8)
+	format := intField(tiffSampleFormat, 1)
+	compression := intField(tiffCompression, 1)
+	predictor := intField(tiffPredictor, 1)
+	if width < 2 || height < 2 {
+		return nil, fmt.Errorf("%s: image too small (%dx%d)", filename, width, height)
+	}
+	if intField(tiffSamplesPerPixel, 1) != 1 {
+		return nil, fmt.Errorf("%s: only single band images are supported", filename)
+	}
+	if compression != 1 && compression != 8 && compression != 32946 {
+		return nil, fmt.Errorf("%s: unsupported compression %d", filename, compression)
+	}
+	if predictor != 1 && (predictor != 2 || format == 3) {
+		return nil, fmt.Errorf("%s: unsupported predictor %d", filename, predictor)
+	}
+	// integer samples are read as raw bits first so that the horizontal
+	// differencing predictor can wrap around like the stored type
+	var raw func([]byte) uint64
+	switch bits {
+	case 8:
+		raw = func(b []byte) uint64 { return uint64(b[0]) }
+	case 16:
+		raw = func(b []byte) uint64 { return uint64(bo.Uint16(b)) }
+	case 32:
+		raw = func(b []byte) uint64 { return uint64(bo.Uint32(b)) }
+	case 64:
+		raw = func(b []byte) uint64 { return bo.Uint64(b) }
+	}
+	var value func(uint64) float64
+	switch {
+	case format == 1 && bits <= 32:
+		value = func(u uint64) float64 { return float64(u) }
+	case format == 2 && bits <= 32:
+		value = func(u uint64) float64 { return float64(int64(u<<(64-bits)) >> (64 - bits)) }
+	case format == 3 && bits == 32:
+		value = func(u uint64) float64 { return float64(math.Float32frombits(uint32(u))) }
+	case format == 3 && bits == 64:
+		value = math.Float64frombits
+	}
+	if raw == nil || value == nil {
+		return nil, fmt.Errorf("%s: unsupported sample format %d with %d bits", filename, format, bits)
+	}
+	mask := uint64(1)<<bits - 1
+	bytesPerSample := bits / 8
+
+	// strips are handled as tiles that are as wide as the image
+	chunkWidth := intField(tiffTileWidth, width)
+	chunkHeight := intField(tiffTileLength, intField(tiffRowsPerStrip, height))
+	offsets, counts := fields[tiffTileOffsets], fields[tiffTileByteCounts]
+	if _, ok := fields[tiffTileOffsets]; !ok {
+		offsets, counts = fields[tiffStripOffsets], fields[tiffStripByteCounts]
+	}
+	chunksAcross := (width + chunkWidth - 1) / chunkWidth
+	if offsets.n == 0 || offsets.n != counts.n {
+		return nil, fmt.Errorf("%s: missing image data offsets", filename)
+	}
+
+	noData := math.NaN()
+	if f, ok := fields[gdalNoData]; ok {
+		noData, err = strconv.ParseFloat(strings.TrimSpace(strings.Trim(string(f.raw), "\x00")), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: can't parse nodata value: %v", filename, err)
+		}
+	}
+
+	g := &grid{
+		width:  width,
+		height: height,
+		data:   make([]float32, width*height),
+	}
+	for i := range g.data {
+		g.data[i] = float32(math.NaN())
+	}
+	for i, offset := range offsets.ints(bo) {
+		count := counts.ints(bo)[i]
+		if offset+count > len(data) {
+			return nil, fmt.Errorf("%s: image data %d out of range", filename, i)
+		}
+		chunk := data[offset : offset+count]
+		if compression != 1 {
+			r, err := zlib.NewReader(bytes.NewReader(chunk))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", filename, err)
+			}
+			chunk, err = io.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", filename, err)
+			}
+		}
+		row0 := i / chunksAcross * chunkHeight
+		col0 := i % chunksAcross * chunkWidth
+		for r := range chunkHeight {
+			if row0+r >= height {
+				break
+			}
+			rowData := chunk[min(len(chunk), r*chunkWidth*bytesPerSample):min(len(chunk), (r+1)*chunkWidth*bytesPerSample)]
+			prev := uint64(0)
+			for c := range len(rowData) / bytesPerSample {
+				u := raw(rowData[c*bytesPerSample:])
+				if predictor == 2 {
+					u = (prev + u) & mask
+					prev = u
+				}
+				v := value(u)
+				if col0+c >= width || v == noData {
+					continue
+				}
+				g.data[(row0+r)*width+col0+c] = float32(v)
+			}
+		}
+	}
+
+	if _, ok := fields[geoModelTransform]; ok {
+		return nil, fmt.Errorf("%s: model transformations are not supported", filename)
+	}
+	scale, tiepoint := fields[geoModelPixelScale], fields[geoModelTiepoint]
+	if scale.n < 2 || tiepoint.n < 6 {
+		return nil, fmt.Errorf("%s: missing georeferencing", filename)
+	}
+	s := scale.floats(bo)
+	t := tiepoint.floats(bo)
+	g.dLon, g.dLat = s[0], s[1]
+	g.west = t[3] - t[0]*g.dLon
+	g.north = t[4] + t[1]*g.dLat
+	pixelIsArea := true
+	if f, ok := fields[geoKeyDirectory]; ok && f.n >= 4 {
+		keys := f.ints(bo)
+		for k := 4; k+3 < len(keys); k += 4 {
+			if keys[k] == geoKeyRasterType && keys[k+1] == 0 {
+				pixelIsArea = keys[k+3] == geoRasterPixelIsArea
+			}
+		}
+	}
+	if pixelIsArea {
+		// tiepoints refer to the corner of a pixel, samples are in the middle
+		g.west += g.dLon / 2
+		g.north -= g.dLat / 2
+	}
+	return g, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"osm2ttd/ttd"
+	"slices"
+)
+
+// Climates, as in Savegame.LandscapeType
+var climates = map[string]uint8{
+	"temperate": 0,
+	"arctic":    1,
+	"tropical":  2,
+	"toyland":   3,
+}
+
+// industryKind is an industry type in a climate, with the cargo types of
+// that climate.
+type industryKind struct {
+	typ      uint8
+	produced [2]uint8
+	rates    [2]uint8
+	accepted [3]uint8
+	layout   [][]uint8 // tile graphics by y and x
+}
+
+const noCargo = 0xff
+
+var (
+	coalMineLayout     = [][]uint8{{5, 6, 3}, {4, 0, 3}, {4, 2, 3}}
+	powerStationLayout = [][]uint8{{7, 7, 7, 10}, {9, 8, 8, 10}}
+	forestLayout       = [][]uint8{{16, 16, 16, 16}, {16, 16, 16, 16}, {16, 16, 16, 16}, {16, 16, 16, 16}}
+	oilRefineryLayout  = [][]uint8{{19, 19, 19, 20}, {19, 19, 21, 20}, {22, 21, 23, 20}}
+	factoryLayout      = [][]uint8{{39, 41}, {40, 42}}
+	farmLayout         = [][]uint8{{37, 33, 35}, {37, 34, 38}, {36, 36, 38}}
+)
+
+// industryKinds are the industries that the rules can place, by name and
+// climate.
+var industryKinds = map[string]map[uint8]industryKind{
+	"coal mine": {
+		0: {0, [2]uint8{1, noCargo}, [2]uint8{13, 0}, [3]uint8{noCargo, noCargo, noCargo}, coalMineLayout},
+		1: {0, [2]uint8{1, noCargo}, [2]uint8{13, 0}, [3]uint8{noCargo, noCargo, noCargo}, coalMineLayout},
+	},
+	"power station": {
+		0: {1, [2]uint8{noCargo, noCargo}, [2]uint8{0, 0}, [3]uint8{1, noCargo, noCargo}, powerStationLayout},
+		1: {1, [2]uint8{noCargo, noCargo}, [2]uint8{0, 0}, [3]uint8{1, noCargo, noCargo}, powerStationLayout},
+	},
+	"forest": {
+		0: {3, [2]uint8{7, noCargo}, [2]uint8{13, 0}, [3]uint8{noCargo, noCargo, noCargo}, forestLayout},
+		1: {3, [2]uint8{7, noCargo}, [2]uint8{13, 0}, [3]uint8{noCargo, noCargo, noCargo}, forestLayout},
+	},
+	"oil refinery": {
+		0: {4, [2]uint8{5, noCargo}, [2]uint8{0, 0}, [3]uint8{3, noCargo, noCargo}, oilRefineryLayout},
+		1: {4, [2]uint8{5, noCargo}, [2]uint8{0, 0}, [3]uint8{3, noCargo, noCargo}, oilRefineryLayout},
+		2: {4, [2]uint8{5, noCargo}, [2]uint8{0, 0}, [3]uint8{3, noCargo, noCargo}, oilRefineryLayout},
+	},
+	"factory": {
+		0: {6, [2]uint8{5, noCargo}, [2]uint8{0, 0}, [3]uint8{4, 6, 9}, factoryLayout},
+	},
+	"farm": {
+		0: {9, [2]uint8{6, 4}, [2]uint8{10, 10}, [3]uint8{noCargo, noCargo, noCargo}, farmLayout},
+		1: {9, [2]uint8{6, 4}, [2]uint8{10, 10}, [3]uint8{noCargo, noCargo, noCargo}, farmLayout},
+	},
+}
+
+// industrySite is an area or a node where a rule wants an industry. Areas
+// of trees can also be sites.
+type industrySite struct {
+	rule   *rule
+	centre point
+	area   int // in tiles
+}
+
+// addSite adds an industry site for an area, if it is on the map.
+func (l *layers) addSite(r *rule, rings [][]point) {
+	area := 0
+	fillPolygon(rings, func(x, y int) {
+		area++
+	})
+	if area == 0 {
+		return
+	}
+	l.sites = append(l.sites, industrySite{r, centroid(rings[0]), area})
+}
+
+// minIndustryDistance is the distance between industries of the same type.
+const minIndustryDistance = 16
+
+// placeIndustries places an industry near the centre of each site, on clear
+// tiles or trees, starting from the largest sites. Each industry belongs to the
+// closest town.
+func placeIndustries(s *ttd.Savegame, sites []industrySite) {
+	if len(sites) == 0 {
+		return
+	}
+	if len(s.Towns) == 0 {
+		fmt.Printf("Industries: none placed, because there are no towns\n")
+		return
+	}
+	slices.SortStableFunc(sites, func(a, b industrySite) int {
+		return cmp.Compare(b.area, a.area)
+	})
+
+	// offsets from the centre, closest first
+	var offsets []tileXY
+	for dy := -6; dy <= 6; dy++ {
+		for dx := -6; dx <= 6; dx++ {
+			offsets = append(offsets, tileXY{dx, dy})
+		}
+	}
+	slices.SortStableFunc(offsets, func(a, b tileXY) int {
+		return cmp.Compare(a.x*a.x+a.y*a.y, b.x*b.x+b.y*b.y)
+	})
+
+	notAvailable, tooClose, noRoom, tooMany := 0, 0, 0, 0
+	for _, site := range sites {
+		kind, ok := industryKinds[site.rule.Industry][s.LandscapeType]
+		if !ok {
+			notAvailable++
+			continue
+		}
+		if len(s.Industries) == 0x5a {
+			tooMany++
+			continue
+		}
+		w, h := len(kind.layout[0]), len(kind.layout)
+		cx, cy := int(site.centre.x)-w/2, int(site.centre.y)-h/2
+		if slices.ContainsFunc(s.Industries, func(i ttd.Industry) bool {
+			return i.Type == kind.typ && abs(int(i.X)-cx)+abs(int(i.Y)-cy) < minIndustryDistance
+		}) {
+			tooClose++
+			continue
+		}
+
+		placed := false
+		for _, o := range offsets {
+			x, y := cx+o.x, cy+o.y
+			if fitsIndustry(s, x, y, w, h) {
+				addIndustry(s, kind, x, y)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			noRoom++
+		}
+	}
+	fmt.Printf("Industries: %d placed, %d not available in this climate, %d too close to another of the same type, %d without room, %d over the limit\n",
+		len(s.Industries), notAvailable, tooClose, noRoom, tooMany)
+}
+
+// fitsIndustry returns whether the tiles of an industry are all clear or
+// trees, and not on the edge of the map.
+func fitsIndustry(s *ttd.Savegame, x, y, w, h int) bool {
+	if x < 1 || y < 1 || x+w > 254 || y+h > 254 {
+		return false
+	}
+	for dy := range h {
+		for dx := range w {
+			if c := s.Tiles[xyToTile(x+dx, y+dy)].Class; c != 0 && c != 4 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func addIndustry(s *ttd.Savegame, kind industryKind, x, y int) {
+	town := 0
+	for i, t := range s.Towns {
+		if abs(int(t.X)-x)+abs(int(t.Y)-y) < abs(int(s.Towns[town].X)-x)+abs(int(s.Towns[town].Y)-y) {
+			town = i
+		}
+	}
+	index := len(s.Industries)
+	s.Industries = append(s.Industries, ttd.Industry{
+		X:                  uint8(x),
+		Y:                  uint8(y),
+		Town:               uint8(town),
+		Width:              uint8(len(kind.layout[0])),
+		Height:             uint8(len(kind.layout)),
+		ProducedCargo:      kind.produced,
+		ProductionRate:     kind.rates,
+		AcceptedCargo:      kind.accepted,
+		ProductionLevel:    16, // normal
+		Type:               kind.typ,
+		Owner:              0x10, // no owner
+		Colour:             uint8(index * 5 % 16),
+		LastProductionYear: s.Year,
+	})
+	for dy, row := range kind.layout {
+		for dx, gfx := range row {
+			s.Tiles[xyToTile(x+dx, y+dy)] = ttd.Tile{
+				Class:    8,
+				Type:     gfx,
+				Height:   s.Tiles[xyToTile(x+dx, y+dy)].Height,
+				Industry: uint8(index),
+				Stage:    3, // completed
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"osm2ttd/ttd"
+	"slices"
+)
+
+// candidate is the tile that a feature wants on a map tile.
+type candidate struct {
+	rule   *rule
+	pieces uint8 // road pieces or rail tracks
+	area   int   // fields: the area they belong to
+}
+
+// layers collects the candidates of all features before they are written to
+// the map, so that the result doesn't depend on the order of the features in
+// the input.
+type layers struct {
+	tiles      []candidate
+	crossings  []candidate // roads on rail tiles, which may become level crossings
+	structures []structure // bridges and tunnels, which depend on what is under them
+	bridges    []bridge
+	tunnels    []tunnel
+	sites      []industrySite
+	areas      int               // number of areas of fields
+	zones      []uint8           // tropic zones of the areas
+	conflicts  map[[2]string]int // number of tiles by winning and losing rule
+}
+
+func newLayers() *layers {
+	return &layers{
+		tiles:     make([]candidate, ttd.NumberOfTiles),
+		crossings: make([]candidate, ttd.NumberOfTiles),
+		zones:     make([]uint8, ttd.NumberOfTiles),
+		conflicts: map[[2]string]int{},
+	}
+}
+
+// wins returns whether rule a wins over rule b on the same tile: the one with
+// the higher priority, or the one earlier in the rules if they are equal.
+func (a *rule) wins(b *rule) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.order < b.order
+}
+
+// join joins two roads or rails on the same tile.
+func join(t *candidate, c candidate) {
+	if t.rule == nil || c.rule.wins(t.rule) {
+		t.rule = c.rule
+	}
+	t.pieces |= c.pieces
+}
+
+// add adds a candidate for a tile. Roads or rails on the same tile are
+// joined, and roads on rail are kept aside for level crossings.
+func (l *layers) add(x, y int, c candidate) {
+	i := xyToTile(x, y)
+	t := &l.tiles[i]
+	switch {
+	case t.rule == nil:
+		*t = c
+	case t.rule.isLine() && t.rule.Class == c.rule.Class:
+		join(t, c)
+	case t.rule.Class == 1 && c.rule.Class == 2:
+		join(&l.crossings[i], c)
+	case t.rule.Class == 2 && c.rule.Class == 1:
+		join(&l.crossings[i], *t)
+		*t = c
+	case c.rule.wins(t.rule):
+		l.conflict(c.rule, t.rule)
+		*t = c
+	default:
+		l.conflict(t.rule, c.rule)
+	}
+}
+
+// conflict counts a tile where the rules would give different tiles.
+func (l *layers) conflict(winner, loser *rule) {
+	if winner.Class != loser.Class || winner.Type != loser.Type || winner.owner() != loser.owner() {
+		l.conflicts[[2]string{winner.Name, loser.Name}]++
+	}
+}
+
+// set adds the rule's tile as a candidate.
+func (l *layers) set(r *rule, x, y int) {
+	l.add(x, y, candidate{rule: r})
+}
+
+// resolve writes the winning candidates to the map and logs the conflicts.
+func (l *layers) resolve(s *ttd.Savegame) {
+	for i, c := range l.tiles {
+		if c.rule == nil {
+			continue
+		}
+		c.rule.setTile(s, i%256, i/256)
+		if c.rule.isLine() {
+			s.Tiles[i].Type = c.pieces
+		}
+		if c.rule.isFields() {
+			s.Tiles[i].Field = uint8(tileHash(c.area, 0) % fieldTypes)
+		}
+	}
+	l.resolveCrossings(s)
+
+	var keys [][2]string
+	for k := range l.conflicts {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b [2]string) int {
+		return cmp.Or(l.conflicts[b]-l.conflicts[a], cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	})
+	for _, k := range keys {
+		fmt.Printf("Conflict: %s over %s on %d tiles\n", k[0], k[1], l.conflicts[k])
+	}
+}
+
+// resolveCrossings turns the tiles where a road crosses straight rail into
+// level crossings. A road that crosses the rail diagonally is straightened,
+// and the tiles before and after the crossing are made road, so that it is
+// reachable in the game. The road only takes those tiles over from rules with
+// a lower priority, and never from rail. Elsewhere the road or rail with the
+// higher priority is kept.
+func (l *layers) resolveCrossings(s *ttd.Savegame) {
+	crossings, cut := 0, 0
+	for i, road := range l.crossings {
+		rail := l.tiles[i]
+		if road.rule == nil || rail.rule.Class != 1 {
+			continue
+		}
+		x, y := i%256, i/256
+		var axis uint8
+		var approaches [2]tileXY
+		switch {
+		case x > 0 && s.Tiles[i-1].Crossing || y > 0 && s.Tiles[i-256].Crossing:
+			// the road already crosses next to this tile
+			l.cutRoad(s, i, road, rail)
+			cut++
+			continue
+		case rail.pieces == ttd.TrackY && l.crosses(x, y, 0):
+			axis = 0 // road along x
+			approaches = [2]tileXY{{x - 1, y}, {x + 1, y}}
+		case rail.pieces == ttd.TrackX && l.crosses(x, y, 1):
+			axis = 1 // road along y
+			approaches = [2]tileXY{{x, y - 1}, {x, y + 1}}
+		default:
+			l.cutRoad(s, i, road, rail)
+			cut++
+			continue
+		}
+		if !l.canApproach(road.rule, approaches) {
+			l.cutRoad(s, i, road, rail)
+			cut++
+			continue
+		}
+
+		pieces := uint8(pieceSW | pieceNE)
+		if axis == 1 {
+			pieces = pieceNW | pieceSE
+		}
+		for _, a := range approaches {
+			t := &l.tiles[xyToTile(a.x, a.y)]
+			if t.rule != nil && t.rule.Class == 2 {
+				continue
+			}
+			if t.rule != nil {
+				l.conflict(road.rule, t.rule)
+			}
+			*t = candidate{rule: road.rule, pieces: pieces}
+			road.rule.setTile(s, a.x, a.y)
+			s.Tiles[xyToTile(a.x, a.y)].Type = pieces
+		}
+		road.rule.setTile(s, x, y)
+		t := &s.Tiles[i]
+		t.Crossing = true
+		t.Axis = axis
+		t.RailOwner = rail.rule.owner()
+		crossings++
+	}
+	if crossings > 0 || cut > 0 {
+		fmt.Printf("Level crossings: %d, %d other tiles with road and rail resolved by priority\n", crossings, cut)
+	}
+}
+
+// crosses returns whether the road on a rail tile continues on both sides of
+// the rail, next to the tile or diagonally, so that it can cross along the
+// given axis. Roads that only run along the rail don't cross it.
+func (l *layers) crosses(x, y int, axis uint8) bool {
+	isRoad := func(x, y int) bool {
+		if x < 0 || y < 0 || x > 255 || y > 255 {
+			return false
+		}
+		i := xyToTile(x, y)
+		return (l.tiles[i].rule != nil && l.tiles[i].rule.Class == 2) || l.crossings[i].rule != nil
+	}
+	for _, side := range []int{-1, 1} {
+		found := false
+		for along := -1; along <= 1; along++ {
+			if axis == 0 && isRoad(x+side, y+along) || axis == 1 && isRoad(x+along, y+side) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// canApproach returns whether the road can have the tiles before and after a
+// level crossing.
+func (l *layers) canApproach(r *rule, approaches [2]tileXY) bool {
+	for _, a := range approaches {
+		if a.x < 0 || a.y < 0 || a.x > 255 || a.y > 255 {
+			return false
+		}
+		t := l.tiles[xyToTile(a.x, a.y)]
+		if t.rule != nil && t.rule.Class != 2 && (t.rule.Class == 1 || !r.wins(t.rule)) {
+			return false
+		}
+	}
+	return true
+}
+
+// cutRoad keeps the road or the rail on a tile where they can't cross.
+func (l *layers) cutRoad(s *ttd.Savegame, i int, road, rail candidate) {
+	if road.rule.wins(rail.rule) {
+		road.rule.setTile(s, i%256, i/256)
+		s.Tiles[i].Type = road.pieces
+		l.conflict(road.rule, rail.rule)
+	} else {
+		l.conflict(rail.rule, road.rule)
+	}
+}
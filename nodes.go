@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"runtime"
+	"slices"
+
+	"github.com/paulmach/osm"
+	"github.com/paulmach/osm/osmpbf"
+)
+
+type location struct {
+	lat, lon float64
+}
+
+// nodeStore keeps the coordinates of nodes until the ways using them are read.
+type nodeStore interface {
+	add(n *osm.Node)
+	get(id osm.NodeID) (location, bool)
+}
+
+// mapNodeStore keeps all nodes.
+type mapNodeStore map[osm.NodeID]location
+
+func (m mapNodeStore) add(n *osm.Node) {
+	m[n.ID] = location{n.Lat, n.Lon}
+}
+
+func (m mapNodeStore) get(id osm.NodeID) (location, bool) {
+	l, ok := m[id]
+	return l, ok
+}
+
+// sortedNodeStore only keeps the nodes whose IDs are known in advance. The
+// coordinates are packed in units of 1e-7 degrees like in PBF files, so each
+// node takes 16 bytes.
+type sortedNodeStore struct {
+	ids        []osm.NodeID
+	lats, lons []int32
+}
+
+const (
+	coordinateScale = 1e7
+	missingLat      = math.MinInt32
+)
+
+func newSortedNodeStore(ids []osm.NodeID) *sortedNodeStore {
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	s := &sortedNodeStore{
+		ids:  slices.Clip(ids),
+		lats: make([]int32, len(ids)),
+		lons: make([]int32, len(ids)),
+	}
+	for i := range s.lats {
+		s.lats[i] = missingLat
+	}
+	return s
+}
+
+func (s *sortedNodeStore) add(n *osm.Node) {
+	if i, ok := slices.BinarySearch(s.ids, n.ID); ok {
+		s.lats[i] = int32(math.Round(n.Lat * coordinateScale))
+		s.lons[i] = int32(math.Round(n.Lon * coordinateScale))
+	}
+}
+
+func (s *sortedNodeStore) get(id osm.NodeID) (location, bool) {
+	i, ok := slices.BinarySearch(s.ids, id)
+	if !ok || s.lats[i] == missingLat {
+		return location{}, false
+	}
+	return location{float64(s.lats[i]) / coordinateScale, float64(s.lons[i]) / coordinateScale}, true
+}
+
+// usedNodeIDs returns the IDs of the nodes of the ways that are converted, so
+// that only those have to be kept.
+func usedNodeIDs(in io.Reader, memberWays map[osm.WayID][]osm.NodeID) ([]osm.NodeID, error) {
+	scanner := osmpbf.New(context.Background(), in, 3)
+	scanner.SkipNodes = true
+	scanner.SkipRelations = true
+	defer scanner.Close()
+
+	var ids []osm.NodeID
+	for objects := 0; scanner.Scan(); objects++ {
+		if objects%memorySampleInterval == 0 {
+			sampleMemory()
+		}
+		w, ok := scanner.Object().(*osm.Way)
+		if ok && w.Visible && isConvertedWay(w, memberWays) {
+			for _, wn := range w.Nodes {
+				ids = append(ids, wn.ID)
+			}
+		}
+	}
+	sampleMemory()
+	return ids, scanner.Err()
+}
+
+// memorySampleInterval is the number of objects read between memory samples.
+const memorySampleInterval = 1 << 20
+
+// peakMemory is the highest heap usage seen by sampleMemory.
+var peakMemory uint64
+
+func sampleMemory() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	peakMemory = max(peakMemory, m.HeapInuse)
+}
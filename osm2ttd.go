@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"osm2ttd/ttd"
 	"slices"
@@ -15,31 +17,30 @@ import (
 )
 
 var (
-	minLat   float64
-	maxLat   float64
-	minLon   float64
-	maxLon   float64
-	size     = flag.Float64("size", 0.1, "Size of the map in degrees")
-	townTags = flag.String("towns", "village,city", "OpenStreetMaps tags to count as towns")
-	roadTags = flag.String("roads", "roads,motorway,trunk,primary,secondary,tertiary,unclassified,residential", "OpenStreetMaps tags to count as roads")
+	size             = flag.Float64("size", 0.1, "Size of the map in degrees of latitude")
+	tileSize         = flag.Float64("tilesize", 0, "Size of a tile in metres, overrides size")
+	bbox             = flag.String("bbox", "", "Area to fit in the map as minlon,minlat,maxlon,maxlat, instead of LATITUDE LONGITUDE")
+	projectionName   = flag.String("projection", "equirectangular", "Map projection, equirectangular or mercator")
+	rotate           = flag.Int("rotate", 0, "Rotate the map clockwise in the game by a multiple of 90 degrees, by default north is up-left")
+	mirror           = flag.Bool("mirror", false, "Mirror the map left-right in the game")
+	townTags         = flag.String("towns", "village,city", "OpenStreetMaps tags to count as towns")
+	roadTags         = flag.String("roads", "roads,motorway,trunk,primary,secondary,tertiary,unclassified,residential", "OpenStreetMaps tags to count as roads, if no rules file is given")
+	railOwner        = flag.Int("railowner", -1, "Company slot (0-7) that owns the railways, by default nobody")
+	rulesFile        = flag.String("rules", "", "JSON file with rules for converting OpenStreetMaps tags to tiles")
+	dumpRules        = flag.Bool("dumprules", false, "Print the default rules as JSON and exit")
+	demFiles         = flag.String("dem", "", "Comma-separated list of SRTM .hgt or GeoTIFF elevation files")
+	verticalScale    = flag.Float64("vscale", 50, "Metres of elevation per height level")
+	seaLevel         = flag.Float64("sealevel", 0, "Elevation in metres of the lowest land height level")
+	lowMemory        = flag.Bool("lowmem", false, "Read the input an extra time to only keep the nodes that are needed, for large extracts")
+	climate          = flag.String("climate", "temperate", "Climate of the map: temperate, arctic, tropical or toyland")
+	treeDensity      = flag.Float64("trees", 3, "Average number of trees per tile in forests and woods, up to 4")
+	buildingCoverage = flag.Float64("buildingcoverage", 0.3, "Fraction of a tile a building has to cover to make it a house tile")
 )
 
-func coordToXY(c float64, lat bool) int {
-	if lat {
-		return int(255 - (c-minLat)/(*size)*256)
-	} else {
-		return int(255 - (c-minLon)/(*size)*256)
-	}
-}
-
 func xyToTile(X, Y int) int {
 	return Y*256 + X
 }
 
-func coordToTile(lat float64, lon float64) int {
-	return xyToTile(coordToXY(lat, true), coordToXY(lon, false))
-}
-
 func abs(i int) int {
 	if i < 0 {
 		return -i
@@ -47,58 +48,342 @@ func abs(i int) int {
 	return i
 }
 
-func road(s *ttd.Savegame, x1, y1, x2, y2 int) {
-	d := 0
-	xm := 1.0
-	ym := 1.0
-	pieces := 15
+// line calls fn for each tile on the line between two tiles. Consecutive
+// tiles always share an edge, because roads and rivers can't connect at the
+// corners. At each step it moves along the axis whose next tile edge the line
+// crosses first.
+func line(x1, y1, x2, y2 int, fn func(x, y int)) {
+	nx, ny := abs(x2-x1), abs(y2-y1)
+	sx, sy := 1, 1
+	if x2 < x1 {
+		sx = -1
+	}
+	if y2 < y1 {
+		sy = -1
+	}
+	x, y := x1, y1
+	fn(x, y)
+	for ix, iy := 0, 0; ix < nx || iy < ny; {
+		// compare (ix+0.5)/nx and (iy+0.5)/ny, going along x at exact corners
+		if iy == ny || (ix < nx && (1+2*ix)*ny <= (1+2*iy)*nx) {
+			x += sx
+			ix++
+		} else {
+			y += sy
+			iy++
+		}
+		fn(x, y)
+	}
+}
+
+func road(l *layers, r *rule, x1, y1, x2, y2 int) {
+	pieces := pieceNW | pieceSW | pieceSE | pieceNE
 	if x1 != x2 || y1 != y2 {
 		if abs(x2-x1) >= abs(y2-y1) {
-			d = abs(x2 - x1)
-			pieces = 10
-			if x1 > x2 {
-				xm = -1.0
+			pieces = pieceSW | pieceNE
+		} else {
+			pieces = pieceNW | pieceSE
+		}
+	}
+	line(x1, y1, x2, y2, func(x, y int) {
+		l.add(x, y, candidate{rule: r, pieces: uint8(pieces)})
+	})
+}
+
+// Road pieces, by the neighbour they connect to.
+const (
+	pieceNW = 1 << iota // y - 1
+	pieceSW             // x + 1
+	pieceSE             // y + 1
+	pieceNE             // x - 1
+)
+
+// connectRoads sets the pieces of each road tile to connect to the road tiles
+// next to it. A tile next to a single road tile becomes a dead end, and a
+// tile without any keeps the direction of its road. Level crossings, bridge
+// heads and tunnel entrances only connect along their road.
+func connectRoads(s *ttd.Savegame) {
+	isRoad := func(x, y int, axis uint8) bool {
+		if x < 0 || y < 0 || x > 255 || y > 255 {
+			return false
+		}
+		t := s.Tiles[xyToTile(x, y)]
+		switch {
+		case t.Class == 2:
+			return !t.Crossing || t.Axis == axis
+		case t.Class == 9 && t.Transport == 1 && t.Tunnel:
+			return t.Direction%2 == axis
+		case t.Class == 9 && t.Transport == 1 && t.BridgeEnd != 0:
+			return t.Axis == axis
+		}
+		return false
+	}
+	for y := range 256 {
+		for x := range 256 {
+			if t := s.Tiles[xyToTile(x, y)]; t.Class != 2 || t.Crossing {
+				continue
 			}
-			ym = float64(y2-y1) / float64(abs(x2-x1))
+			pieces := uint8(0)
+			if isRoad(x, y-1, 1) {
+				pieces |= pieceNW
+			}
+			if isRoad(x+1, y, 0) {
+				pieces |= pieceSW
+			}
+			if isRoad(x, y+1, 1) {
+				pieces |= pieceSE
+			}
+			if isRoad(x-1, y, 0) {
+				pieces |= pieceNE
+			}
+			if pieces != 0 {
+				s.Tiles[xyToTile(x, y)].Type = pieces
+			}
+		}
+	}
+}
+
+// tileXY is a tile on a path.
+type tileXY struct {
+	x, y int
+}
+
+// edge returns the road piece towards the next tile on a path.
+func edge(from, to tileXY) uint8 {
+	switch {
+	case to.y < from.y:
+		return pieceNW
+	case to.x > from.x:
+		return pieceSW
+	case to.y > from.y:
+		return pieceSE
+	default:
+		return pieceNE
+	}
+}
+
+// track returns the rail track that connects the given edges, or a straight
+// track if there is only one.
+func track(edges uint8) uint8 {
+	switch edges {
+	case pieceNW | pieceSE, pieceNW, pieceSE:
+		return ttd.TrackY
+	case pieceNE | pieceNW:
+		return ttd.TrackUpper
+	case pieceSW | pieceSE:
+		return ttd.TrackLower
+	case pieceSW | pieceNW:
+		return ttd.TrackLeft
+	case pieceNE | pieceSE:
+		return ttd.TrackRight
+	default:
+		return ttd.TrackX
+	}
+}
+
+// addPath adds the tiles path[from:to] of a road or rail path of
+// edge-connected tiles, connecting each tile to the tiles before and after it
+// on the path.
+func addPath(l *layers, r *rule, path []tileXY, from, to int) {
+	for i := from; i < to; i++ {
+		t := path[i]
+		edges := uint8(0)
+		if i > 0 {
+			edges |= edge(t, path[i-1])
+		}
+		if i < len(path)-1 {
+			edges |= edge(t, path[i+1])
+		}
+		pieces := edges
+		if r.Class == 1 {
+			pieces = track(edges)
+		} else if edges == 0 {
+			pieces = pieceNW | pieceSW | pieceSE | pieceNE
+		}
+		l.add(t.x, t.y, candidate{rule: r, pieces: pieces})
+	}
+}
+
+// wayPaths returns the tiles of the parts of the way inside the map as
+// edge-connected paths.
+func wayPaths(w *osm.Way, nodes nodeStore) [][]tileXY {
+	var paths [][]tileXY
+	var path []tileXY
+	forEachSegment(w, nodes, func(x1, y1, x2, y2 int) {
+		if len(path) > 0 && path[len(path)-1] != (tileXY{x1, y1}) {
+			paths = append(paths, path)
+			path = nil
+		}
+		line(x1, y1, x2, y2, func(x, y int) {
+			if len(path) == 0 || path[len(path)-1] != (tileXY{x, y}) {
+				path = append(path, tileXY{x, y})
+			}
+		})
+	})
+	if len(path) > 0 {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// isConvertedWay returns whether the way or its nodes are used for the map.
+func isConvertedWay(w *osm.Way, memberWays map[osm.WayID][]osm.NodeID) bool {
+	_, member := memberWays[w.ID]
+	return member || matchRule(w.Tags) != nil
+}
+
+// forEachSegment calls fn for each segment of the way whose both ends are
+// inside the map.
+func forEachSegment(w *osm.Way, nodes nodeStore, fn func(x1, y1, x2, y2 int)) {
+	prevValid := false
+	var prevX, prevY int
+	for _, wn := range w.Nodes {
+		n, ok := nodes.get(wn.ID)
+		if ok && inBBox(n.lat, n.lon) {
+			curX, curY := coordToXY(n.lat, n.lon)
+			if prevValid {
+				fn(prevX, prevY, curX, curY)
+			}
+			prevValid = true
+			prevX = curX
+			prevY = curY
 		} else {
-			d = abs(y2 - y1)
-			pieces = 5
-			if y1 > y2 {
-				ym = -1.0
+			prevValid = false
+		}
+	}
+}
+
+// wayPoints returns the points of a closed way, or false if it isn't closed
+// or some of its nodes are missing.
+func wayPoints(w *osm.Way, nodes nodeStore) ([]point, bool) {
+	if len(w.Nodes) < 4 || w.Nodes[0].ID != w.Nodes[len(w.Nodes)-1].ID {
+		return nil, false
+	}
+	var points []point
+	for _, wn := range w.Nodes {
+		n, ok := nodes.get(wn.ID)
+		if !ok {
+			return nil, false
+		}
+		points = append(points, coordToPoint(n.lat, n.lon))
+	}
+	return points, true
+}
+
+// convertWay converts a way with the matching rule.
+func convertWay(l *layers, w *osm.Way, r *rule, nodes nodeStore, coast *[]coastSegment) {
+	if r.Sea {
+		for i := 1; i < len(w.Nodes); i++ {
+			a, aOK := nodes.get(w.Nodes[i-1].ID)
+			b, bOK := nodes.get(w.Nodes[i].ID)
+			if aOK && bOK {
+				*coast = append(*coast, coastSegment{a.lat, a.lon, b.lat, b.lon})
 			}
-			xm = float64(x2-x1) / float64(abs(y2-y1))
 		}
+		return
 	}
-	for i := 0; i <= d; i++ {
-		x := x1 + int(float64(i)*xm)
-		y := y1 + int(float64(i)*ym)
-		s.Tiles[xyToTile(x, y)].Class = 2
-		s.Tiles[xyToTile(x, y)].Type = uint8(pieces)
+	if r.isArea() {
+		if points, ok := wayPoints(w, nodes); ok {
+			fillArea(l, r, [][]point{points})
+			return
+		}
+	}
+	if r.Class == 8 {
+		return // industries need an area
+	}
+	if r.isLine() && (isBridge(w.Tags) || isTunnel(w.Tags)) {
+		for _, path := range wayPaths(w, nodes) {
+			l.structures = append(l.structures, structure{way: w.ID, rule: r, tunnel: isTunnel(w.Tags), path: path})
+		}
+		return
 	}
+	if r.Class == 1 {
+		for _, path := range wayPaths(w, nodes) {
+			addPath(l, r, path, 0, len(path))
+		}
+		return
+	}
+	forEachSegment(w, nodes, func(x1, y1, x2, y2 int) {
+		if r.Class == 2 {
+			road(l, r, x1, y1, x2, y2)
+		} else {
+			line(x1, y1, x2, y2, func(x, y int) {
+				l.set(r, x, y)
+			})
+		}
+	})
 }
 
 func main() {
 	flag.Parse()
-	if flag.NArg() != 4 {
-		panic("Usage: osm2ttd [--size=0.1] INFILE OUTFILE LATITUDE LONGITUDE")
+	if *dumpRules {
+		out, err := json.MarshalIndent(defaultRules(), "", "\t")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	if (*bbox == "" && flag.NArg() != 4) || (*bbox != "" && flag.NArg() != 2) {
+		panic("Usage: osm2ttd [--size=0.1] INFILE OUTFILE LATITUDE LONGITUDE\n       osm2ttd --bbox=MINLON,MINLAT,MAXLON,MAXLAT INFILE OUTFILE")
 	}
 	inFilename := flag.Arg(0)
 	outFile := flag.Arg(1)
-	lat, err := strconv.ParseFloat(flag.Arg(2), 64)
-	if err != nil {
-		panic(err)
+	var err error
+	if *bbox != "" {
+		proj, err = newBBoxProjection(*projectionName, *bbox)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		lat, err := strconv.ParseFloat(flag.Arg(2), 64)
+		if err != nil {
+			panic(err)
+		}
+		lon, err := strconv.ParseFloat(flag.Arg(3), 64)
+		if err != nil {
+			panic(err)
+		}
+		metresPerTile := *tileSize
+		if metresPerTile == 0 {
+			metresPerTile = *size * metresPerDegree / 256
+		}
+		proj, err = newProjection(*projectionName, lat, lon, metresPerTile)
+		if err != nil {
+			panic(err)
+		}
 	}
-	lon, err := strconv.ParseFloat(flag.Arg(3), 64)
+	rs := defaultRules()
+	if *rulesFile != "" {
+		rs, err = loadRules(*rulesFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	setRules(rs)
+	if *railOwner > 7 {
+		panic(fmt.Sprintf("Rail owner %d is not a company slot (0-7)", *railOwner))
+	}
+	if *verticalScale <= 0 {
+		panic(fmt.Sprintf("Vertical scale %g is not positive", *verticalScale))
+	}
+	if *treeDensity < 0 || *treeDensity > 4 {
+		panic(fmt.Sprintf("Tree density %g is not between 0 and 4", *treeDensity))
+	}
+	landscape, ok := climates[*climate]
+	if !ok {
+		panic(fmt.Sprintf("Unknown climate %q", *climate))
+	}
+
+	err = proj.setOrientation(*rotate, *mirror)
 	if err != nil {
 		panic(err)
 	}
-	minLat = lat - *size/2
-	maxLat = lat + *size/2
-	minLon = lon - *size/2
-	maxLon = lon + *size/2
+	fmt.Printf("Map: %.0f metres per tile\n", proj.metresPerTile())
 
 	s := ttd.Savegame{
 		Title:          inFilename,
+		LandscapeType:  landscape,
 		MaxInitialLoan: 50000,
 		Tiles: slices.Repeat([]ttd.Tile{ttd.Tile{
 			Height: 1,
@@ -107,34 +392,81 @@ func main() {
 		}}, ttd.NumberOfTiles),
 	}
 
+	if *demFiles != "" {
+		grids, err := loadDEM(*demFiles)
+		if err != nil {
+			panic(err)
+		}
+		applyElevation(&s, grids)
+	}
+
 	in, err := os.Open(inFilename)
 	if err != nil {
 		panic(err)
 	}
 	defer in.Close()
 
+	multipolygons, err := readMultipolygons(in)
+	if err != nil {
+		panic(err)
+	}
+	memberWays := make(map[osm.WayID][]osm.NodeID)
+	for _, m := range multipolygons {
+		for _, id := range slices.Concat(m.outer, m.inner) {
+			memberWays[id] = nil
+		}
+	}
+	_, err = in.Seek(0, io.SeekStart)
+	if err != nil {
+		panic(err)
+	}
+
+	var nodes nodeStore = make(mapNodeStore)
+	if *lowMemory {
+		ids, err := usedNodeIDs(in, memberWays)
+		if err != nil {
+			panic(err)
+		}
+		nodes = newSortedNodeStore(ids)
+		fmt.Printf("Low memory mode: keeping %d nodes\n", len(ids))
+		_, err = in.Seek(0, io.SeekStart)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	scanner := osmpbf.New(context.Background(), in, 3)
 	scanner.SkipRelations = true
 	defer scanner.Close()
 
-	nodes := make(map[osm.NodeID]*osm.Node)
-	for scanner.Scan() {
+	l := newLayers()
+	var coast []coastSegment
+	var seaRule *rule
+	for objects := 0; scanner.Scan(); objects++ {
+		if objects%memorySampleInterval == 0 {
+			sampleMemory()
+		}
 		o := scanner.Object()
 		switch o.(type) {
 		case *osm.Node:
 			n := o.(*osm.Node)
-			nodes[n.ID] = n
-			if minLat < n.Lat && n.Lat < maxLat && minLon < n.Lon && n.Lon < maxLon {
+			nodes.add(n)
+			if inBBox(n.Lat, n.Lon) {
 				isTown := false
+				x, y := coordToXY(n.Lat, n.Lon)
 				town := ttd.Town{
-					X: uint8(coordToXY(n.Lon, false)),
-					Y: uint8(coordToXY(n.Lat, true)),
+					X: uint8(x),
+					Y: uint8(y),
 				}
-				for _, t := range n.Tags {
-					if t.Key == "building" && t.Value == "isolated_dwelling" {
-						s.Tiles[coordToTile(n.Lat, n.Lon)].Class = 3
-						s.Tiles[coordToTile(n.Lat, n.Lon)].Type = 0x18
+				if r := matchRule(n.Tags); r != nil && !r.isLine() && !r.Sea {
+					if r.Industry != "" {
+						l.sites = append(l.sites, industrySite{r, point{float64(x) + 0.5, float64(y) + 0.5}, 1})
 					}
+					if r.Class != 8 {
+						l.set(r, x, y)
+					}
+				}
+				for _, t := range n.Tags {
 					if t.Key == "place" && slices.Contains(strings.Split(*townTags, ","), t.Value) {
 						isTown = true
 					}
@@ -150,36 +482,14 @@ func main() {
 		case *osm.Way:
 			w := o.(*osm.Way)
 			if w.Visible {
-				for _, t := range w.Tags {
-					if t.Key == "building" {
-						for _, wn := range w.Nodes {
-							n := nodes[wn.ID]
-							if minLat < n.Lat && n.Lat < maxLat && minLon < n.Lon && n.Lon < maxLon {
-								s.Tiles[coordToTile(n.Lat, n.Lon)].Class = 3
-								s.Tiles[coordToTile(n.Lat, n.Lon)].Type = 0x06
-								break
-							}
-						}
-					}
-					if t.Key == "highway" && slices.Contains(strings.Split(*roadTags, ","), t.Value) {
-						prevValid := false
-						var prevX, prevY int
-						for _, wn := range w.Nodes {
-							n := nodes[wn.ID]
-							if minLat < n.Lat && n.Lat < maxLat && minLon < n.Lon && n.Lon < maxLon {
-								curX := coordToXY(n.Lon, false)
-								curY := coordToXY(n.Lat, true)
-								if prevValid {
-									road(&s, prevX, prevY, curX, curY)
-								}
-								prevValid = true
-								prevX = curX
-								prevY = curY
-							} else {
-								prevValid = false
-							}
-						}
+				if _, ok := memberWays[w.ID]; ok {
+					memberWays[w.ID] = w.Nodes.NodeIDs()
+				}
+				if r := matchRule(w.Tags); r != nil {
+					if r.Sea {
+						seaRule = r
 					}
+					convertWay(l, w, r, nodes, &coast)
 				}
 			}
 		}
@@ -188,6 +498,35 @@ func main() {
 	if err := scanner.Err(); err != nil {
 		panic(err)
 	}
+	sampleMemory()
+
+	dropped := 0
+	for _, m := range multipolygons {
+		rings, d := m.rings(memberWays, nodes)
+		dropped += d
+		if len(rings) > 0 {
+			fillArea(l, m.rule, rings)
+		}
+	}
+	fmt.Printf("Multipolygons: %d read, %d incomplete rings or missing members skipped\n", len(multipolygons), dropped)
+
+	if len(coast) > 0 {
+		fmt.Printf("Coastline: %d sea tiles\n", fillSea(l, coast, seaRule))
+	}
+	l.setZones(&s)
+	l.planStructures(&s)
+	l.resolve(&s)
+	l.buildBridges(&s)
+	placeIndustries(&s, l.sites)
+	l.finishFields(&s)
+
+	fmt.Printf("Terrain: changed the height of %d tiles\n", normaliseTerrain(&s))
+	l.checkBridges(&s)
+	l.buildTunnels(&s)
+	connectRoads(&s)
+
+	sampleMemory()
+	fmt.Printf("Peak heap memory: %d MiB\n", peakMemory>>20)
 
 	f, err := os.Create(outFile)
 	if err != nil {
@@ -0,0 +1,1408 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"osm2ttd/ttd"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/paulmach/osm"
+)
+
+func lineTiles(x1, y1, x2, y2 int) []tileXY {
+	var tiles []tileXY
+	line(x1, y1, x2, y2, func(x, y int) {
+		tiles = append(tiles, tileXY{x, y})
+	})
+	return tiles
+}
+
+func TestLine(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		x1, y1, x2, y2 int
+		want           []tileXY
+	}{
+		{"point", 5, 5, 5, 5, []tileXY{{5, 5}}},
+		{"x axis", 1, 2, 4, 2, []tileXY{{1, 2}, {2, 2}, {3, 2}, {4, 2}}},
+		{"y axis backwards", 3, 3, 3, 0, []tileXY{{3, 3}, {3, 2}, {3, 1}, {3, 0}}},
+		{"diagonal", 0, 0, 2, 2, []tileXY{{0, 0}, {1, 0}, {1, 1}, {2, 1}, {2, 2}}},
+		{"shallow", 0, 0, 4, 1, []tileXY{{0, 0}, {1, 0}, {2, 0}, {2, 1}, {3, 1}, {4, 1}}},
+		{"steep backwards", 1, 4, 0, 0, []tileXY{{1, 4}, {1, 3}, {1, 2}, {0, 2}, {0, 1}, {0, 0}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, lineTiles(tc.x1, tc.y1, tc.x2, tc.y2), cmp.AllowUnexported(tileXY{})); diff != "" {
+				t.Errorf("line(%d, %d, %d, %d) mismatch (-want +got):\n%s", tc.x1, tc.y1, tc.x2, tc.y2, diff)
+			}
+		})
+	}
+}
+
+func TestLineIsEdgeConnected(t *testing.T) {
+	ends := []tileXY{{0, 0}, {255, 0}, {0, 255}, {100, 37}, {37, 100}, {128, 129}, {200, 201}, {17, 250}}
+	for _, a := range ends {
+		for _, b := range ends {
+			tiles := lineTiles(a.x, a.y, b.x, b.y)
+			if tiles[0] != a || tiles[len(tiles)-1] != b {
+				t.Fatalf("line from %v to %v goes from %v to %v", a, b, tiles[0], tiles[len(tiles)-1])
+			}
+			if len(tiles) != abs(b.x-a.x)+abs(b.y-a.y)+1 {
+				t.Errorf("line from %v to %v has %d tiles", a, b, len(tiles))
+			}
+			length := math.Hypot(float64(b.x-a.x), float64(b.y-a.y))
+			for i, p := range tiles {
+				if i > 0 && abs(p.x-tiles[i-1].x)+abs(p.y-tiles[i-1].y) != 1 {
+					t.Fatalf("line from %v to %v jumps from %v to %v", a, b, tiles[i-1], p)
+				}
+				if length > 0 {
+					// distance of the tile centre from the line through the end tile centres
+					d := math.Abs(float64((b.x-a.x)*(a.y-p.y)-(a.x-p.x)*(b.y-a.y))) / length
+					if d > math.Sqrt2/2 {
+						t.Errorf("line from %v to %v goes through %v, %.2f tiles from the line", a, b, p, d)
+					}
+				}
+			}
+		}
+	}
+}
+
+// ruleForClass returns the first default rule for a tile class.
+func ruleForClass(class uint8) *rule {
+	setRules(defaultRules())
+	for i := range rules {
+		if rules[i].Class == class {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// ruleNamed returns the default rule with the given name.
+func ruleNamed(name string) *rule {
+	setRules(defaultRules())
+	for i := range rules {
+		if rules[i].Name == name {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func TestMatchRule(t *testing.T) {
+	setRules([]rule{
+		{Name: "named", Match: []predicate{{Key: "name", Value: "*"}}, Class: 0, Priority: 1},
+		{Name: "road", Match: []predicate{{Key: "highway", Value: "primary|secondary"}}, Class: 2, Priority: 40},
+		{Name: "link", Match: []predicate{{Key: "highway", Value: "*_link"}}, Class: 2, Priority: 40},
+		{Name: "rail", Match: []predicate{{Key: "railway", Value: "rail"}, {Key: "tunnel", Not: true}}, Class: 1, Priority: 50},
+		{Name: "water", Match: []predicate{{Key: "natural|waterway", Value: "water|riverbank"}}, Class: 6, Priority: 10},
+		{Name: "grass", Match: []predicate{{Key: "landuse", Value: "grass"}}, Class: 0, Priority: 5},
+		{Name: "landuse", Match: []predicate{{Key: "landuse"}}, Class: 0, Priority: 5},
+	})
+	defer setRules(defaultRules())
+	for _, tc := range []struct {
+		tags osm.Tags
+		want string
+	}{
+		{osm.Tags{{Key: "name", Value: "A/B"}}, "named"},
+		{osm.Tags{{Key: "highway", Value: "secondary"}}, "road"},
+		{osm.Tags{{Key: "highway", Value: "tertiary"}}, ""},
+		{osm.Tags{{Key: "highway", Value: "primary_link"}}, "link"},
+		{osm.Tags{{Key: "name", Value: "High Street"}, {Key: "highway", Value: "primary"}}, "road"}, // higher priority
+		{osm.Tags{{Key: "railway", Value: "rail"}}, "rail"},
+		{osm.Tags{{Key: "railway", Value: "rail"}, {Key: "tunnel", Value: "yes"}}, ""}, // not a tunnel
+		{osm.Tags{{Key: "waterway", Value: "riverbank"}}, "water"},
+		{osm.Tags{{Key: "natural", Value: "riverbank"}}, "water"},
+		{osm.Tags{{Key: "landuse", Value: "grass"}}, "grass"}, // same priority, earlier rule
+		{osm.Tags{{Key: "landuse", Value: "farmland"}}, "landuse"},
+		{osm.Tags{{Key: "amenity", Value: "bench"}}, ""},
+	} {
+		got := ""
+		if r := matchRule(tc.tags); r != nil {
+			got = r.Name
+		}
+		if got != tc.want {
+			t.Errorf("%v matches rule %q, want %q", tc.tags, got, tc.want)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	for _, tc := range []struct {
+		name, json, wantErr string
+	}{
+		{"valid", `[{"name": "r", "match": [{"key": "landuse", "value": "quarry"}], "class": 8, "industry": "coal mine"}]`, ""},
+		{"bad json", `[{"name": "r"`, "unexpected end"},
+		{"class", `[{"name": "r", "match": [{"key": "a"}], "class": 5}]`, "unsupported tile class 5"},
+		{"industry without name", `[{"name": "r", "match": [{"key": "a"}], "class": 8}]`, `unknown industry ""`},
+		{"unknown industry", `[{"name": "r", "match": [{"key": "a"}], "class": 4, "industry": "bakery"}]`, `unknown industry "bakery"`},
+		{"unknown zone", `[{"name": "r", "match": [{"key": "a"}], "class": 4, "zone": "tundra"}]`, `unknown tropic zone "tundra"`},
+		{"industry on road", `[{"name": "r", "match": [{"key": "a"}], "class": 2, "industry": "farm"}]`, "can have an industry"},
+		{"no match", `[{"name": "r", "class": 0}]`, "doesn't match any tags"},
+		{"bad pattern", `[{"name": "r", "match": [{"key": "a", "value": "b|[c"}], "class": 0}]`, `bad pattern "[c"`},
+	} {
+		rs, err := loadRules(writeFile(t, "rules.json", []byte(tc.json)))
+		switch {
+		case tc.wantErr == "" && (err != nil || len(rs) != 1):
+			t.Errorf("%s: got %d rules and error %v, want 1 rule", tc.name, len(rs), err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestRoadPieces(t *testing.T) {
+	r := ruleForClass(2)
+	l := newLayers()
+	// a diagonal road and a road crossing it
+	road(l, r, 10, 10, 13, 13)
+	road(l, r, 13, 10, 13, 15)
+	s := ttd.Savegame{Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+	l.resolve(&s)
+	connectRoads(&s)
+
+	want := map[tileXY]uint8{
+		{10, 10}: pieceSW,
+		{11, 10}: pieceNE | pieceSE,
+		{11, 11}: pieceNW | pieceSW,
+		{12, 11}: pieceNE | pieceSW | pieceSE,
+		{13, 11}: pieceNW | pieceNE | pieceSE,
+		{12, 12}: pieceNW | pieceSW,
+		{13, 12}: pieceNW | pieceNE | pieceSE,
+		{13, 10}: pieceSE,
+		{13, 13}: pieceNW | pieceSE,
+		{13, 14}: pieceNW | pieceSE,
+		{13, 15}: pieceNW,
+	}
+	for y := range 256 {
+		for x := range 256 {
+			tile := s.Tiles[xyToTile(x, y)]
+			pieces, ok := want[tileXY{x, y}]
+			if !ok {
+				if tile.Class == 2 {
+					t.Errorf("unexpected road at %d, %d", x, y)
+				}
+				continue
+			}
+			if tile.Class != 2 || tile.Type != pieces {
+				t.Errorf("tile %d, %d is class %d with pieces %d, want road with pieces %d", x, y, tile.Class, tile.Type, pieces)
+			}
+		}
+	}
+}
+
+func TestConnectRoads(t *testing.T) {
+	s := ttd.Savegame{Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+	roads := map[tileXY]uint8{
+		// a T junction
+		{20, 20}: pieceSW | pieceNE,
+		{21, 20}: pieceSW | pieceNE,
+		{22, 20}: pieceSW | pieceNE,
+		{21, 21}: pieceNW | pieceSE,
+		// a tile without neighbours keeps its direction
+		{40, 40}: pieceNW | pieceSE,
+		// a level crossing with the road along x, next to a road along y
+		{61, 60}: pieceSW | pieceNE,
+		{60, 61}: pieceNW | pieceSE,
+	}
+	for p, pieces := range roads {
+		s.Tiles[xyToTile(p.x, p.y)] = ttd.Tile{Class: 2, Type: pieces}
+	}
+	s.Tiles[xyToTile(60, 60)] = ttd.Tile{Class: 2, Crossing: true, Axis: 0}
+	connectRoads(&s)
+
+	for p, want := range map[tileXY]uint8{
+		{20, 20}: pieceSW,
+		{21, 20}: pieceNE | pieceSW | pieceSE,
+		{22, 20}: pieceNE,
+		{21, 21}: pieceNW,
+		{40, 40}: pieceNW | pieceSE,
+		{61, 60}: pieceNE,
+		{60, 61}: pieceNW | pieceSE, // not connected to the crossing along y
+	} {
+		if got := s.Tiles[xyToTile(p.x, p.y)].Type; got != want {
+			t.Errorf("tile %d, %d has pieces %d, want %d", p.x, p.y, got, want)
+		}
+	}
+}
+
+func TestRailTracks(t *testing.T) {
+	r := ruleForClass(1)
+	l := newLayers()
+	// straight, then a diagonal staircase
+	path := []tileXY{{0, 5}, {1, 5}, {2, 5}, {2, 6}, {3, 6}, {3, 7}}
+	addPath(l, r, path, 0, len(path))
+	want := []uint8{ttd.TrackX, ttd.TrackX, ttd.TrackRight, ttd.TrackLeft, ttd.TrackRight, ttd.TrackY}
+	for i, p := range path {
+		if got := l.tiles[xyToTile(p.x, p.y)].pieces; got != want[i] {
+			t.Errorf("tile %d, %d has track %d, want %d", p.x, p.y, got, want[i])
+		}
+	}
+}
+
+func TestLevelCrossing(t *testing.T) {
+	railRule, roadRule := ruleForClass(1), ruleForClass(2)
+	l := newLayers()
+	path := []tileXY{{0, 5}, {1, 5}, {2, 5}, {3, 5}, {4, 5}, {5, 5}}
+	addPath(l, railRule, path, 0, len(path))
+	// a road crossing at a right angle, and one that runs along the rail
+	road(l, roadRule, 1, 2, 1, 8)
+	road(l, roadRule, 3, 4, 6, 5)
+	s := ttd.Savegame{Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+	l.resolve(&s)
+	connectRoads(&s)
+
+	want := ttd.Tile{Class: 2, Owner: 0x10, Crossing: true, Axis: 1, RailOwner: 0x10}
+	if diff := cmp.Diff(want, s.Tiles[xyToTile(1, 5)]); diff != "" {
+		t.Errorf("crossing mismatch (-want +got):\n%s", diff)
+	}
+	if got := s.Tiles[xyToTile(1, 4)]; got.Class != 2 || got.Type != pieceNW|pieceSE {
+		t.Errorf("road before the crossing is %+v", got)
+	}
+	for x := 3; x <= 5; x++ {
+		if got := s.Tiles[xyToTile(x, 5)]; got.Class != 1 || got.Type != ttd.TrackX {
+			t.Errorf("tile %d, 5 is %+v, want rail", x, got)
+		}
+	}
+}
+
+func TestLevelCrossingApproaches(t *testing.T) {
+	railRule, roadRule, house := ruleForClass(1), ruleForClass(2), ruleForClass(3)
+	lake := *ruleForClass(6)
+	lake.Priority = roadRule.Priority + 1
+	for _, tc := range []struct {
+		name      string
+		approach  *rule // on the tile after the first place where the road could cross
+		crossing  tileXY
+		conflicts map[[2]string]int
+	}{
+		{"free", nil, tileXY{7, 10}, map[[2]string]int{{"railway", "road"}: 1}},
+		{"lower priority", house, tileXY{7, 10}, map[[2]string]int{{"railway", "road"}: 1, {"road", house.Name}: 1}},
+		{"higher priority", &lake, tileXY{8, 10}, map[[2]string]int{{"railway", "road"}: 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newLayers()
+			path := []tileXY{{5, 10}, {6, 10}, {7, 10}, {8, 10}, {9, 10}, {10, 10}}
+			addPath(l, railRule, path, 0, len(path))
+			// a road that runs along the rail for two tiles on its way across
+			for _, p := range []tileXY{{7, 8}, {7, 9}, {7, 10}, {8, 10}, {8, 11}, {8, 12}} {
+				l.add(p.x, p.y, candidate{rule: roadRule, pieces: pieceNW | pieceSE})
+			}
+			if tc.approach != nil {
+				l.set(tc.approach, 7, 11)
+			}
+			s := ttd.Savegame{Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+			l.resolve(&s)
+			connectRoads(&s)
+
+			for x := 7; x <= 8; x++ {
+				got := s.Tiles[xyToTile(x, 10)]
+				if want := (tileXY{x, 10} == tc.crossing); got.Crossing != want || !want && got.Class != 1 {
+					t.Errorf("tile %d, 10 is %+v, wanted a crossing: %v", x, got, want)
+				}
+			}
+			c := tc.crossing
+			for _, a := range []struct {
+				tileXY
+				piece uint8 // towards the crossing
+			}{{tileXY{c.x, c.y - 1}, pieceSE}, {tileXY{c.x, c.y + 1}, pieceNW}} {
+				if got := s.Tiles[xyToTile(a.x, a.y)]; got.Class != 2 || got.Type&a.piece == 0 {
+					t.Errorf("approach %d, %d is %+v, wanted road to the crossing", a.x, a.y, got)
+				}
+			}
+			if diff := cmp.Diff(tc.conflicts, l.conflicts); diff != "" {
+				t.Errorf("conflicts mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBridge(t *testing.T) {
+	water, roadRule := ruleForClass(6), ruleForClass(2)
+	l := newLayers()
+	for y := range 10 {
+		l.set(water, 5, y)
+		l.set(water, 6, y)
+	}
+	// a diagonal way over the river, so the bridge has to be straightened
+	var path []tileXY
+	line(2, 3, 9, 6, func(x, y int) {
+		path = append(path, tileXY{x, y})
+	})
+	l.structures = append(l.structures, structure{rule: roadRule, path: path})
+	s := ttd.Savegame{Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+	l.planStructures(&s)
+	l.resolve(&s)
+	l.buildBridges(&s)
+	l.checkBridges(&s)
+	connectRoads(&s)
+
+	var bridge []ttd.Tile
+	for x := range 10 {
+		for y := range 10 {
+			if tile := s.Tiles[xyToTile(x, y)]; tile.Class == 9 {
+				bridge = append(bridge, tile)
+			}
+		}
+	}
+	want := []ttd.Tile{
+		{Class: 9, Owner: 0x10, Transport: 1, BridgeEnd: 1},
+		{Class: 9, Owner: 0x10, Transport: 1, BridgePiece: 0, UnderBridge: ttd.UnderBridgeWater},
+		{Class: 9, Owner: 0x10, Transport: 1, BridgePiece: 1, UnderBridge: ttd.UnderBridgeWater},
+		{Class: 9, Owner: 0x10, Transport: 1, BridgeEnd: 2},
+	}
+	if diff := cmp.Diff(want, bridge); diff != "" {
+		t.Errorf("bridge mismatch (-want +got):\n%s", diff)
+	}
+	for x := 2; x <= 9; x++ {
+		for y := 3; y <= 6; y++ {
+			if tile := s.Tiles[xyToTile(x, y)]; tile.Class == 2 && (x == 5 || x == 6) {
+				t.Errorf("road on the river at %d, %d", x, y)
+			}
+		}
+	}
+}
+
+func TestBridgeFromOtherBank(t *testing.T) {
+	water, roadRule := ruleForClass(6), ruleForClass(2)
+	l := newLayers()
+	for y := range 10 {
+		l.set(water, 5, y)
+		l.set(water, 6, y)
+	}
+	// a pond behind the bank that the way reaches first, so only the
+	// bridge from the other bank fits
+	l.set(water, 8, 3)
+	path := []tileXY{{2, 3}, {3, 3}, {4, 3}, {5, 3}, {5, 4}, {6, 4}, {7, 4}, {8, 4}, {9, 4}}
+	l.structures = append(l.structures, structure{rule: roadRule, path: path})
+	s := ttd.Savegame{Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+	l.planStructures(&s)
+	l.resolve(&s)
+	l.buildBridges(&s)
+
+	var bridge []tileXY
+	for y := range 10 {
+		for x := range 10 {
+			if s.Tiles[xyToTile(x, y)].Class == 9 {
+				bridge = append(bridge, tileXY{x, y})
+			}
+		}
+	}
+	want := []tileXY{{4, 4}, {5, 4}, {6, 4}, {7, 4}}
+	if !slices.Equal(want, bridge) {
+		t.Errorf("Got bridge tiles %v, want %v", bridge, want)
+	}
+}
+
+func TestPlaceIndustries(t *testing.T) {
+	quarry := ruleNamed("quarry")
+	s := ttd.Savegame{
+		Towns: []ttd.Town{{X: 50, Y: 50}, {X: 10, Y: 10}},
+		Tiles: make([]ttd.Tile, ttd.NumberOfTiles),
+	}
+	// a house where the coal mine would go, and another site too close
+	s.Tiles[xyToTile(10, 10)].Class = 3
+	placeIndustries(&s, []industrySite{
+		{quarry, point{10.5, 10.5}, 4},
+		{quarry, point{12.5, 12.5}, 1},
+	})
+
+	if len(s.Industries) != 1 {
+		t.Fatalf("%d industries placed, want 1", len(s.Industries))
+	}
+	i := s.Industries[0]
+	if i.Type != 0 || i.Town != 1 || i.Width != 3 || i.Height != 3 {
+		t.Errorf("industry = %+v", i)
+	}
+	for y := int(i.Y); y < int(i.Y+i.Height); y++ {
+		for x := int(i.X); x < int(i.X+i.Width); x++ {
+			if tile := s.Tiles[xyToTile(x, y)]; tile.Class != 8 || tile.Industry != 0 {
+				t.Errorf("tile %d, %d = %+v, want an industry tile", x, y, tile)
+			}
+		}
+	}
+	if s.Tiles[xyToTile(10, 10)].Class != 3 {
+		t.Errorf("industry placed over the house")
+	}
+
+	// cargo types of the temperate climate
+	placeIndustries(&s, []industrySite{
+		{ruleNamed("works"), point{100, 100}, 4},
+		{ruleNamed("power plant"), point{150, 150}, 4},
+	})
+	for _, tc := range []struct {
+		typ      uint8
+		produced [2]uint8
+		accepted [3]uint8
+	}{
+		{6, [2]uint8{5, noCargo}, [3]uint8{4, 6, 9}},                   // factory: goods from livestock, grain and steel
+		{1, [2]uint8{noCargo, noCargo}, [3]uint8{1, noCargo, noCargo}}, // power station: coal
+	} {
+		i := slices.IndexFunc(s.Industries, func(i ttd.Industry) bool { return i.Type == tc.typ })
+		if i < 0 {
+			t.Errorf("no industry of type %d placed", tc.typ)
+			continue
+		}
+		if got := s.Industries[i]; got.ProducedCargo != tc.produced || got.AcceptedCargo != tc.accepted {
+			t.Errorf("industry of type %d produces %v and accepts %v, want %v and %v", tc.typ, got.ProducedCargo, got.AcceptedCargo, tc.produced, tc.accepted)
+		}
+	}
+}
+
+func TestTrees(t *testing.T) {
+	defer func(d float64) { *treeDensity = d }(*treeDensity)
+	for _, density := range []float64{0, 1.5, 4} {
+		*treeDensity = density
+		for climate, species := range treeSpecies {
+			trees := 0
+			for x := range 100 {
+				tile := ttd.Tile{Class: 4, Owner: 0x10}
+				setTrees(&tile, x, 7, climate, ttd.TropicRainforest)
+				if tile.Class == 0 {
+					continue
+				}
+				trees += int(tile.Trees)
+				if tile.Type < species[0] || tile.Type >= species[0]+species[1] {
+					t.Errorf("climate %d: species %d", climate, tile.Type)
+				}
+			}
+			if got := float64(trees) / 100; got < density-0.2 || got > density+0.2 {
+				t.Errorf("density %g, climate %d: %g trees per tile", density, climate, got)
+			}
+		}
+	}
+}
+
+func TestFieldHedges(t *testing.T) {
+	farmland := ruleNamed("farmland")
+	l := newLayers()
+	// two fields next to each other, 3x2 and 2x2 tiles
+	fillArea(l, farmland, [][]point{{{2, 2}, {5, 2}, {5, 4}, {2, 4}}})
+	fillArea(l, farmland, [][]point{{{5, 2}, {7, 2}, {7, 4}, {5, 4}}})
+	// a coal mine, but no farm the fields could belong to
+	s := ttd.Savegame{Tiles: make([]ttd.Tile, ttd.NumberOfTiles), Industries: []ttd.Industry{{X: 3, Y: 3}}}
+	l.resolve(&s)
+	l.finishFields(&s)
+
+	var hedges [2][8]string
+	for y := 2; y < 4; y++ {
+		for x := 2; x < 7; x++ {
+			tile := s.Tiles[xyToTile(x, y)]
+			if tile.Type>>2 != ttd.ClearFields {
+				t.Errorf("tile %d, %d isn't a field", x, y)
+			}
+			if tile.Industry != ttd.NoIndustry {
+				t.Errorf("field %d, %d belongs to industry %d", x, y, tile.Industry)
+			}
+			h := ""
+			if tile.HedgeSE != 0 {
+				h += "E"
+			}
+			if tile.HedgeSW != 0 {
+				h += "W"
+			}
+			hedges[y-2][x] = h
+		}
+	}
+	want := [2][8]string{
+		{2: "", 3: "", 4: "W", 5: "", 6: "W"},
+		{2: "E", 3: "E", 4: "EW", 5: "E", 6: "EW"},
+	}
+	if diff := cmp.Diff(want, hedges); diff != "" {
+		t.Errorf("hedges mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGround(t *testing.T) {
+	tests := []struct {
+		climate    uint8
+		ground     uint8
+		wantGround uint8
+		wantZones  bool
+	}{
+		{0, ttd.ClearRocks, ttd.ClearRocks, false},
+		{0, ttd.ClearSnow, ttd.ClearRough, false},
+		{1, ttd.ClearSnow, ttd.ClearSnow, false},
+		{1, ttd.ClearDesert, ttd.ClearRough, false},
+		{2, ttd.ClearDesert, ttd.ClearDesert, true},
+	}
+	for _, test := range tests {
+		s := ttd.Savegame{LandscapeType: test.climate, Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+		s.Tiles[xyToTile(3, 4)].Type = test.ground<<2 | 3
+		setGround(&s, 3, 4)
+		if got := s.Tiles[xyToTile(3, 4)].Type >> 2; got != test.wantGround {
+			t.Errorf("climate %d, ground %d: got ground %d, want %d", test.climate, test.ground, got, test.wantGround)
+		}
+		if (s.TropicZones != nil) != test.wantZones || test.wantZones && s.TropicZones[xyToTile(3, 4)] != ttd.TropicDesert {
+			t.Errorf("climate %d, ground %d: tropic zones %v", test.climate, test.ground, s.TropicZones != nil)
+		}
+	}
+}
+
+func TestTropicZones(t *testing.T) {
+	wood := ruleNamed("wood")
+	for _, climate := range []uint8{0, 2} {
+		l := newLayers()
+		fillArea(l, wood, [][]point{{{2, 2}, {6, 2}, {6, 6}, {2, 6}}})
+		s := ttd.Savegame{LandscapeType: climate, Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+		l.setZones(&s)
+		l.resolve(&s)
+		if climate != 2 {
+			if s.TropicZones != nil {
+				t.Errorf("climate %d: tropic zones set", climate)
+			}
+			continue
+		}
+		for y := range 8 {
+			for x := range 8 {
+				want := uint8(ttd.TropicNormal)
+				if x >= 2 && x < 6 && y >= 2 && y < 6 {
+					want = ttd.TropicRainforest
+				}
+				if got := s.TropicZones[xyToTile(x, y)]; got != want {
+					t.Errorf("tile %d, %d in zone %d, want %d", x, y, got, want)
+				}
+				if tile := s.Tiles[xyToTile(x, y)]; tile.Class == 4 && (tile.Type < 20 || tile.Type > 26) {
+					t.Errorf("tile %d, %d has trees of species %d, want rainforest", x, y, tile.Type)
+				}
+			}
+		}
+	}
+}
+
+// writeFile writes a fixture to a temporary file and returns its name.
+func writeFile(t *testing.T, name string, data []byte) string {
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// equalGrid compares samples, where NaN equals NaN.
+var equalGrid = cmp.Comparer(func(a, b float32) bool {
+	return a == b || (math.IsNaN(float64(a)) && math.IsNaN(float64(b)))
+})
+
+func TestReadHGT(t *testing.T) {
+	var data []byte
+	for _, v := range []int16{0, 10, 20, 30, 40, 50, 60, 70, -32768} {
+		data = binary.BigEndian.AppendUint16(data, uint16(v))
+	}
+	g, err := readHGT(writeFile(t, "S01W002.hgt", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nan := float32(math.NaN())
+	want := []float32{0, 10, 20, 30, 40, 50, 60, 70, nan}
+	if diff := cmp.Diff(want, g.data, equalGrid); diff != "" {
+		t.Errorf("data mismatch (-want +got):\n%s", diff)
+	}
+	if g.north != 0 || g.west != -2 || g.dLat != 0.5 || g.dLon != 0.5 {
+		t.Errorf("Got north %g, west %g, %g by %g degrees, wanted 0, -2, 0.5 by 0.5", g.north, g.west, g.dLat, g.dLon)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		lat, lon float64
+		want     float64
+		ok       bool
+	}{
+		{"sample", -0.5, -1.5, 40, true},
+		{"between samples", -0.25, -1.75, 20, true},
+		{"next to void", -0.75, -1.25, (40 + 50 + 70) / 3.0, true},
+		{"void", -1, -1, 0, false},
+		{"outside", 0.1, -1.5, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := g.elevation(tc.lat, tc.lon)
+			if ok != tc.ok || math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("elevation(%g, %g) = %g, %v, wanted %g, %v", tc.lat, tc.lon, got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+
+	if _, err := readHGT(writeFile(t, "N01E002.hgt", data[:10])); err == nil {
+		t.Errorf("readHGT accepted a file of 10 bytes")
+	}
+	if _, err := readHGT(writeFile(t, "elevation.hgt", data)); err == nil {
+		t.Errorf("readHGT accepted a file name without a location")
+	}
+}
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffFixture returns a GeoTIFF of 16 bit signed samples, in strips of
+// chunkHeight rows, or in tiles if chunkWidth isn't 0.
+func tiffFixture(bo byteOrder, width, height, chunkWidth, chunkHeight int, samples []int16, noData string, pixelIsPoint bool) []byte {
+	data := []byte("II")
+	if bo == binary.BigEndian {
+		data = []byte("MM")
+	}
+	data = bo.AppendUint16(data, 42)
+	data = bo.AppendUint32(data, 0) // IFD offset, set at the end
+
+	tiled := chunkWidth != 0
+	if !tiled {
+		chunkWidth = width
+	}
+	var offsets, counts []byte
+	for row0 := 0; row0 < height; row0 += chunkHeight {
+		for col0 := 0; col0 < width; col0 += chunkWidth {
+			offsets = bo.AppendUint32(offsets, uint32(len(data)))
+			start := len(data)
+			for r := row0; r < row0+chunkHeight && (tiled || r < height); r++ {
+				for c := col0; c < col0+chunkWidth; c++ {
+					v := int16(0)
+					if r < height && c < width {
+						v = samples[r*width+c]
+					}
+					data = bo.AppendUint16(data, uint16(v))
+				}
+			}
+			counts = bo.AppendUint32(counts, uint32(len(data)-start))
+		}
+	}
+
+	type entry struct {
+		tag, typ uint16
+		raw      []byte
+	}
+	short := func(tag uint16, values ...int) entry {
+		var raw []byte
+		for _, v := range values {
+			raw = bo.AppendUint16(raw, uint16(v))
+		}
+		return entry{tag, 3, raw}
+	}
+	double := func(tag uint16, values ...float64) entry {
+		var raw []byte
+		for _, v := range values {
+			raw = bo.AppendUint64(raw, math.Float64bits(v))
+		}
+		return entry{tag, 12, raw}
+	}
+	rasterType := geoRasterPixelIsArea
+	if pixelIsPoint {
+		rasterType = 2
+	}
+	entries := []entry{
+		short(tiffImageWidth, width),
+		short(tiffImageLength, height),
+		short(tiffBitsPerSample, 16),
+		short(tiffSampleFormat, 2),
+		double(geoModelPixelScale, 0.5, 0.25, 0),
+		double(geoModelTiepoint, 0, 0, 0, 10, 20, 0),
+		short(geoKeyDirectory, 1, 1, 0, 1, geoKeyRasterType, 0, 1, rasterType),
+	}
+	if tiled {
+		entries = append(entries, short(tiffTileWidth, chunkWidth), short(tiffTileLength, chunkHeight),
+			entry{tiffTileOffsets, 4, offsets}, entry{tiffTileByteCounts, 4, counts})
+	} else {
+		entries = append(entries, short(tiffRowsPerStrip, chunkHeight),
+			entry{tiffStripOffsets, 4, offsets}, entry{tiffStripByteCounts, 4, counts})
+	}
+	if noData != "" {
+		entries = append(entries, entry{gdalNoData, 2, append([]byte(noData), 0)})
+	}
+
+	// values that don't fit in an entry go before the IFD
+	values := make([]uint32, len(entries))
+	for i, e := range entries {
+		if len(e.raw) > 4 {
+			values[i] = uint32(len(data))
+			data = append(data, e.raw...)
+		}
+	}
+	bo.PutUint32(data[4:], uint32(len(data)))
+	data = bo.AppendUint16(data, uint16(len(entries)))
+	for i, e := range entries {
+		data = bo.AppendUint16(data, e.tag)
+		data = bo.AppendUint16(data, e.typ)
+		data = bo.AppendUint32(data, uint32(len(e.raw)/tiffTypeSize(e.typ)))
+		if len(e.raw) > 4 {
+			data = bo.AppendUint32(data, values[i])
+		} else {
+			data = append(data, e.raw...)
+			data = append(data, make([]byte, 4-len(e.raw))...)
+		}
+	}
+	return bo.AppendUint32(data, 0) // no next IFD
+}
+
+func TestReadGeoTIFF(t *testing.T) {
+	samples := []int16{
+		1, 2, 3,
+		4, -5, 6,
+		7, 8, -9999,
+	}
+	nan := float32(math.NaN())
+	all := []float32{1, 2, 3, 4, -5, 6, 7, 8, -9999}
+	for _, tc := range []struct {
+		name                    string
+		bo                      byteOrder
+		chunkWidth, chunkHeight int
+		noData                  string
+		pixelIsPoint            bool
+		want                    []float32
+	}{
+		{"little endian strips", binary.LittleEndian, 0, 1, "", true, all},
+		{"big endian strips", binary.BigEndian, 0, 2, "", true, all},
+		{"partial tiles", binary.LittleEndian, 2, 2, "", true, all},
+		{"big endian tiles", binary.BigEndian, 2, 2, "", true, all},
+		{"nodata", binary.LittleEndian, 0, 3, "-9999", true, []float32{1, 2, 3, 4, -5, 6, 7, 8, nan}},
+		{"pixel is area", binary.BigEndian, 3, 3, "", false, all},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := tiffFixture(tc.bo, 3, 3, tc.chunkWidth, tc.chunkHeight, samples, tc.noData, tc.pixelIsPoint)
+			g, err := readGeoTIFF(writeFile(t, "dem.tif", data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, g.data, equalGrid); diff != "" {
+				t.Errorf("data mismatch (-want +got):\n%s", diff)
+			}
+			north, west := 20.0, 10.0
+			if !tc.pixelIsPoint {
+				north, west = 20-0.125, 10+0.25
+			}
+			if g.width != 3 || g.height != 3 || g.north != north || g.west != west || g.dLat != 0.25 || g.dLon != 0.5 {
+				t.Errorf("Got %dx%d from %g, %g by %g, %g degrees, wanted 3x3 from %g, %g by 0.25, 0.5",
+					g.width, g.height, g.north, g.west, g.dLat, g.dLon, north, west)
+			}
+		})
+	}
+
+	if _, err := readGeoTIFF(writeFile(t, "dem.tif", []byte("II*\x00"))); err == nil {
+		t.Errorf("readGeoTIFF accepted a truncated file")
+	}
+}
+
+func TestElevationToHeight(t *testing.T) {
+	for _, tc := range []struct {
+		e    float64
+		want uint8
+	}{
+		{-10, 0},
+		{0, 1},
+		{49, 1},
+		{50, 2},
+		{10000, 15},
+	} {
+		if got := elevationToHeight(tc.e); got != tc.want {
+			t.Errorf("elevationToHeight(%g) = %d, wanted %d", tc.e, got, tc.want)
+		}
+	}
+}
+
+// flatSavegame returns a game with all tiles at the given height.
+func flatSavegame(height uint8) *ttd.Savegame {
+	s := &ttd.Savegame{Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+	for i := range s.Tiles {
+		s.Tiles[i].Height = height
+	}
+	return s
+}
+
+// checkSlopes fails if the corners of a tile differ by more than one level.
+func checkSlopes(t *testing.T, s *ttd.Savegame) {
+	t.Helper()
+	for y := range 255 {
+		for x := range 255 {
+			lowest, highest := uint8(15), uint8(0)
+			for _, c := range tileCorners(x, y) {
+				lowest = min(lowest, s.Tiles[c].Height)
+				highest = max(highest, s.Tiles[c].Height)
+			}
+			if highest > lowest+1 {
+				t.Fatalf("Tile %d, %d is steep, corners from %d to %d", x, y, lowest, highest)
+			}
+		}
+	}
+}
+
+func TestNormaliseTerrain(t *testing.T) {
+	t.Run("steep diagonal", func(t *testing.T) {
+		// corners h, h+1, h+1, h+2 pass the checks along x and y
+		s := flatSavegame(1)
+		for y := range 256 {
+			for x := range 256 {
+				s.Tiles[xyToTile(x, y)].Height = uint8(min(15, 1+(x+y)/2))
+			}
+		}
+		s.Tiles[xyToTile(11, 11)].Height = s.Tiles[xyToTile(10, 10)].Height + 2
+		s.Tiles[xyToTile(11, 10)].Height = s.Tiles[xyToTile(10, 10)].Height + 1
+		s.Tiles[xyToTile(10, 11)].Height = s.Tiles[xyToTile(10, 10)].Height + 1
+		normaliseTerrain(s)
+		checkSlopes(t, s)
+	})
+
+	t.Run("single peak", func(t *testing.T) {
+		s := flatSavegame(1)
+		s.Tiles[xyToTile(50, 50)].Height = 5
+		if got := normaliseTerrain(s); got != 1 {
+			t.Errorf("Changed %d tiles, wanted 1", got)
+		}
+		if h := s.Tiles[xyToTile(50, 50)].Height; h != 2 {
+			t.Errorf("Peak has height %d, wanted 2", h)
+		}
+		checkSlopes(t, s)
+	})
+
+	t.Run("road", func(t *testing.T) {
+		s := flatSavegame(2)
+		s.Tiles[xyToTile(21, 20)].Height = 3
+		s.Tiles[xyToTile(20, 20)] = ttd.Tile{Class: 2, Height: 2, Type: pieceSW | pieceNE}
+		if got := normaliseTerrain(s); got != 1 {
+			t.Errorf("Changed %d tiles, wanted 1", got)
+		}
+		for _, c := range tileCorners(20, 20) {
+			if h := s.Tiles[c].Height; h != 2 {
+				t.Errorf("Road tile corner %d, %d has height %d, wanted 2", c%256, c/256, h)
+			}
+		}
+		checkSlopes(t, s)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		s := flatSavegame(1)
+		s.Tiles[xyToTile(30, 30)].Height = 2
+		if got := normaliseTerrain(s); got != 0 {
+			t.Errorf("Changed %d tiles of valid terrain", got)
+		}
+	})
+}
+
+func TestCoordToXY(t *testing.T) {
+	defer func(p projection) { proj = p }(proj)
+	var err error
+	proj, err = newProjection("equirectangular", 10, 20, metresPerDegree/256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a tile is 1/256 degree, and the map is inverted, with the east edge at
+	// x = 0 and the north edge at y = 0
+	dLat, dLon := 1.0/256, 1.0/256/proj.cosLat
+	lat, lon := 10-dLat/2, 20-dLon/2 // middle of tile 128, 128
+	for _, tc := range []struct {
+		name     string
+		lat, lon float64
+		x, y     int
+	}{
+		{"centre", lat, lon, 128, 128},
+		{"east", lat, lon + 10*dLon, 118, 128},
+		{"north", lat + 10*dLat, lon, 128, 118},
+		{"south-west", lat - 10*dLat, lon - 10*dLon, 138, 138},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if x, y := coordToXY(tc.lat, tc.lon); x != tc.x || y != tc.y {
+				t.Errorf("coordToXY(%g, %g) = %d, %d, wanted %d, %d", tc.lat, tc.lon, x, y, tc.x, tc.y)
+			}
+		})
+	}
+}
+
+func TestFillSea(t *testing.T) {
+	defer func(p projection) { proj = p }(proj)
+	var err error
+	proj, err = newProjection("equirectangular", 0, 0, metresPerDegree/256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// coastline through the given map points, with the sea on the right
+	var coast []coastSegment
+	coastline := func(points ...point) {
+		for i := range len(points) - 1 {
+			lat1, lon1 := pointToCoord(points[i])
+			lat2, lon2 := pointToCoord(points[i+1])
+			coast = append(coast, coastSegment{lat1, lon1, lat2, lon2})
+		}
+	}
+	// the sea is on the high x side, in the game to the south-west, and has
+	// an island in it
+	coastline(point{128, -10}, point{128, 266})
+	coastline(point{190, 120}, point{200, 120}, point{200, 130}, point{190, 130}, point{190, 120})
+	water := ruleForClass(6)
+	l := newLayers()
+	fillSea(l, coast, water)
+
+	isSea := func(x, y int) bool {
+		return l.tiles[xyToTile(x, y)].rule == water
+	}
+	for y := range 256 {
+		for x := range 256 {
+			island := x >= 190 && x < 200 && y >= 120 && y < 130
+			if want := x >= 128 && !island; isSea(x, y) != want {
+				t.Fatalf("tile %d, %d: sea %v, want %v", x, y, isSea(x, y), want)
+			}
+		}
+	}
+}
+
+// pbfFile encodes nodes, ways and relations as an OSM PBF file, with the
+// fields that the converter reads.
+func pbfFile(nodes []*osm.Node, ways []*osm.Way, relations []*osm.Relation) []byte {
+	varint := func(b []byte, v uint64) []byte {
+		return binary.AppendUvarint(b, v)
+	}
+	field := func(b []byte, n int, data []byte) []byte {
+		return append(varint(varint(b, uint64(n)<<3|2), uint64(len(data))), data...)
+	}
+	number := func(b []byte, n int, v uint64) []byte {
+		return varint(varint(b, uint64(n)<<3), v)
+	}
+	// packed varints, zigzag and delta encoded for sint64
+	packed := func(b []byte, n int, vs []int64, delta bool) []byte {
+		var data []byte
+		prev := int64(0)
+		for _, v := range vs {
+			if delta {
+				v, prev = v-prev, v
+				data = varint(data, uint64(v<<1^v>>63))
+			} else {
+				data = varint(data, uint64(v))
+			}
+		}
+		return field(b, n, data)
+	}
+	var strs []string
+	str := func(s string) int64 {
+		i := slices.Index(strs, s)
+		if i < 0 {
+			i = len(strs)
+			strs = append(strs, s)
+		}
+		return int64(i)
+	}
+	str("")
+	tags := func(b []byte, tags osm.Tags) []byte {
+		var keys, vals []int64
+		for _, t := range tags {
+			keys, vals = append(keys, str(t.Key)), append(vals, str(t.Value))
+		}
+		return packed(packed(b, 2, keys, false), 3, vals, false)
+	}
+
+	var dense, groups []byte
+	var ids, lats, lons []int64
+	for _, n := range nodes {
+		ids = append(ids, int64(n.ID))
+		lats = append(lats, int64(math.Round(n.Lat*1e7)))
+		lons = append(lons, int64(math.Round(n.Lon*1e7)))
+	}
+	dense = packed(packed(packed(dense, 1, ids, true), 8, lats, true), 9, lons, true)
+	groups = field(groups, 2, field(nil, 2, dense))
+	var group []byte
+	for _, w := range ways {
+		var refs []int64
+		for _, wn := range w.Nodes {
+			refs = append(refs, int64(wn.ID))
+		}
+		group = field(group, 3, packed(tags(number(nil, 1, uint64(w.ID)), w.Tags), 8, refs, true))
+	}
+	groups = field(groups, 2, group)
+	group = nil
+	for _, r := range relations {
+		var roles, memids, types []int64
+		for _, m := range r.Members {
+			roles, memids = append(roles, str(m.Role)), append(memids, m.Ref)
+			types = append(types, map[osm.Type]int64{osm.TypeNode: 0, osm.TypeWay: 1, osm.TypeRelation: 2}[m.Type])
+		}
+		d := tags(number(nil, 1, uint64(r.ID)), r.Tags)
+		group = field(group, 4, packed(packed(packed(d, 8, roles, false), 9, memids, true), 10, types, false))
+	}
+	groups = field(groups, 2, group)
+
+	var table []byte
+	for _, s := range strs {
+		table = field(table, 1, []byte(s))
+	}
+	block := func(b []byte, typ string, data []byte) []byte {
+		blob := number(field(nil, 1, data), 2, uint64(len(data)))
+		header := number(field(nil, 1, []byte(typ)), 3, uint64(len(blob)))
+		return append(binary.BigEndian.AppendUint32(b, uint32(len(header))), append(header, blob...)...)
+	}
+	file := block(nil, "OSMHeader", field(nil, 4, []byte("OsmSchema-V0.6")))
+	return block(file, "OSMData", append(field(nil, 1, table), groups...))
+}
+
+func TestReadMultipolygons(t *testing.T) {
+	setRules(defaultRules())
+	member := func(role string, ref int64) osm.Member {
+		return osm.Member{Type: osm.TypeWay, Ref: ref, Role: role}
+	}
+	relations := []*osm.Relation{
+		{ID: 1, Tags: osm.Tags{{Key: "type", Value: "multipolygon"}, {Key: "landuse", Value: "forest"}},
+			Members: osm.Members{member("outer", 10), member("inner", 11), member("", 12), {Type: osm.TypeNode, Ref: 5, Role: "label"}}},
+		{ID: 2, Tags: osm.Tags{{Key: "type", Value: "route"}, {Key: "landuse", Value: "forest"}}, Members: osm.Members{member("outer", 20)}},
+		{ID: 3, Tags: osm.Tags{{Key: "type", Value: "multipolygon"}, {Key: "highway", Value: "primary"}}, Members: osm.Members{member("outer", 30)}},
+		{ID: 4, Tags: osm.Tags{{Key: "type", Value: "multipolygon"}, {Key: "amenity", Value: "bench"}}, Members: osm.Members{member("outer", 40)}},
+	}
+	got, err := readMultipolygons(bytes.NewReader(pbfFile(nil, nil, relations)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Got %d multipolygons, want only the forest", len(got))
+	}
+	if m := got[0]; m.rule.Name != "forest" || !slices.Equal(m.outer, []osm.WayID{10, 12}) || !slices.Equal(m.inner, []osm.WayID{11}) {
+		t.Errorf("Got rule %q with outer ways %v and inner ways %v, want forest with 10, 12 and 11", m.rule.Name, m.outer, m.inner)
+	}
+}
+
+func TestJoinRings(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		ways        [][]osm.NodeID
+		want        [][]osm.NodeID
+		wantDropped int
+	}{
+		{"closed way", [][]osm.NodeID{{1, 2, 3, 1}}, [][]osm.NodeID{{1, 2, 3, 1}}, 0},
+		{"two halves", [][]osm.NodeID{{1, 2, 3}, {3, 4, 1}}, [][]osm.NodeID{{1, 2, 3, 4, 1}}, 0},
+		{"reversed half", [][]osm.NodeID{{1, 2, 3}, {1, 4, 3}}, [][]osm.NodeID{{1, 2, 3, 4, 1}}, 0},
+		{"out of order", [][]osm.NodeID{{3, 4}, {1, 2}, {4, 1}, {2, 3}}, [][]osm.NodeID{{3, 4, 1, 2, 3}}, 0},
+		{"two rings", [][]osm.NodeID{{1, 2, 3}, {5, 6, 7, 5}, {3, 1}}, [][]osm.NodeID{{1, 2, 3, 1}, {5, 6, 7, 5}}, 0},
+		{"unclosed", [][]osm.NodeID{{1, 2, 3}, {3, 4}}, nil, 1},
+		{"unclosed next to a ring", [][]osm.NodeID{{1, 2}, {5, 6, 7, 5}}, [][]osm.NodeID{{5, 6, 7, 5}}, 1},
+		{"too short", [][]osm.NodeID{{1, 2}, {2, 1}}, nil, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, dropped := joinRings(tc.ways)
+			if !cmp.Equal(tc.want, got) || dropped != tc.wantDropped {
+				t.Errorf("Got rings %v with %d dropped, want %v with %d dropped", got, dropped, tc.want, tc.wantDropped)
+			}
+		})
+	}
+}
+
+func TestMultipolygonRings(t *testing.T) {
+	defer func(p projection) { proj = p }(proj)
+	var err error
+	proj, err = newProjection("equirectangular", 0, 0, metresPerDegree/256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := mapNodeStore{}
+	for id, p := range map[osm.NodeID]point{1: {10, 10}, 2: {20, 10}, 3: {20, 20}, 4: {10, 20}, 5: {12, 12}, 6: {14, 12}, 7: {14, 14}} {
+		lat, lon := pointToCoord(p)
+		nodes[id] = location{lat, lon}
+	}
+	ways := map[osm.WayID][]osm.NodeID{
+		1: {1, 2, 3},
+		2: {1, 4, 3}, // reversed
+		3: {5, 6, 7, 5},
+		4: {5, 8, 5, 5}, // node 8 is missing
+	}
+	m := multipolygon{outer: []osm.WayID{1, 2, 9}, inner: []osm.WayID{3, 4}} // way 9 is missing
+	rings, dropped := m.rings(ways, nodes)
+	if dropped != 2 {
+		t.Errorf("Got %d dropped, want the missing way and the ring with the missing node", dropped)
+	}
+	round := func(p point) point {
+		return point{math.Round(p.x), math.Round(p.y)}
+	}
+	var got [][]point
+	for _, r := range rings {
+		var ring []point
+		for _, p := range r {
+			ring = append(ring, round(p))
+		}
+		got = append(got, ring)
+	}
+	want := [][]point{
+		{{10, 10}, {20, 10}, {20, 20}, {10, 20}, {10, 10}},
+		{{12, 12}, {14, 12}, {14, 14}, {12, 12}},
+	}
+	if !cmp.Equal(want, got, cmp.AllowUnexported(point{})) {
+		t.Errorf("Got rings %v, want %v", got, want)
+	}
+}
+
+// rect returns the ring of a rectangle.
+func rect(x1, y1, x2, y2 float64) []point {
+	return []point{{x1, y1}, {x2, y1}, {x2, y2}, {x1, y2}, {x1, y1}}
+}
+
+func TestPolygonCoverage(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rings [][]point
+		want  map[tileXY]float64
+	}{
+		{"whole tile", [][]point{rect(0, 0, 1, 1)}, map[tileXY]float64{{0, 0}: 1}},
+		{"half tile", [][]point{rect(1, 1, 1.5, 2)}, map[tileXY]float64{{1, 1}: 0.5}},
+		{"quarter of four tiles", [][]point{rect(2.5, 2.5, 3.5, 3.5)}, map[tileXY]float64{{2, 2}: 0.25, {3, 2}: 0.25, {2, 3}: 0.25, {3, 3}: 0.25}},
+		{"triangle", [][]point{{{0, 0}, {1, 0}, {0, 1}}}, map[tileXY]float64{{0, 0}: 6.0 / 16}},
+		{"hole", [][]point{rect(0, 0, 3, 3), rect(1, 1, 2, 2)}, map[tileXY]float64{
+			{0, 0}: 1, {1, 0}: 1, {2, 0}: 1, {0, 1}: 1, {2, 1}: 1, {0, 2}: 1, {1, 2}: 1, {2, 2}: 1,
+		}},
+		{"hole in the other direction", [][]point{rect(0, 0, 2, 1), {{0.5, 0}, {0.5, 1}, {1.5, 1}, {1.5, 0}}}, map[tileXY]float64{{0, 0}: 0.5, {1, 0}: 0.5}},
+		{"outside the map", [][]point{rect(-2, -2, -1, -1)}, map[tileXY]float64{}},
+		{"across the edge", [][]point{rect(255.5, -0.5, 256.5, 0.5)}, map[tileXY]float64{{255, 0}: 0.25}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := map[tileXY]float64{}
+			polygonCoverage(tc.rings, coverageSamples, func(x, y int, coverage float64) {
+				if _, ok := got[tileXY{x, y}]; ok {
+					t.Errorf("Tile %d, %d reported twice", x, y)
+				}
+				got[tileXY{x, y}] = coverage
+			})
+			if !cmp.Equal(tc.want, got) {
+				t.Errorf("Got coverage %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFillBuilding(t *testing.T) {
+	r := ruleNamed("building")
+	for _, tc := range []struct {
+		name string
+		ring []point
+		want []tileXY
+	}{
+		{"covers both tiles enough", rect(0.5, 0, 2, 1), []tileXY{{0, 0}, {1, 0}}},
+		{"below the coverage on one tile", rect(0.8, 0, 1.6, 1), []tileXY{{1, 0}}},
+		{"small building on the best tile", rect(1.7, 1, 2.6, 1.5), []tileXY{{2, 1}}},
+		{"tiny building on its centre", rect(5.01, 5.01, 5.05, 5.05), []tileXY{{5, 5}}},
+		{"tiny building outside the map", rect(-0.05, 5.01, -0.01, 5.05), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newLayers()
+			fillBuilding(l, r, [][]point{tc.ring})
+			var got []tileXY
+			for i, c := range l.tiles {
+				if c.rule != nil {
+					got = append(got, tileXY{i % 256, i / 256})
+				}
+			}
+			if !cmp.Equal(tc.want, got, cmp.AllowUnexported(tileXY{})) {
+				t.Errorf("Got houses on %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNodeStores(t *testing.T) {
+	nodes := []*osm.Node{
+		{ID: 1, Lat: 51.5007292, Lon: -0.1246254},
+		{ID: 3, Lat: -33.8567844, Lon: 151.2152967},
+		{ID: 7, Lat: 0, Lon: 0},
+		{ID: 8, Lat: -90, Lon: 180},
+		{ID: 9, Lat: 12.3456789, Lon: 98.7654321}, // not used
+	}
+	// duplicates and a node that isn't in the input
+	sorted := newSortedNodeStore([]osm.NodeID{8, 3, 1, 3, 7, 5, 1})
+	all := mapNodeStore{}
+	for _, n := range nodes {
+		sorted.add(n)
+		all.add(n)
+	}
+	for id := range osm.NodeID(11) {
+		want, wantOK := all.get(id)
+		if id == 9 {
+			want, wantOK = location{}, false
+		}
+		got, ok := sorted.get(id)
+		if ok != wantOK || math.Abs(got.lat-want.lat) > 1e-7 || math.Abs(got.lon-want.lon) > 1e-7 {
+			t.Errorf("Node %d: got %v, %t, want %v, %t", id, got, ok, want, wantOK)
+		}
+	}
+}
+
+func TestUsedNodeIDs(t *testing.T) {
+	setRules(defaultRules())
+	nodeRefs := func(ids ...osm.NodeID) osm.WayNodes {
+		var wn osm.WayNodes
+		for _, id := range ids {
+			wn = append(wn, osm.WayNode{ID: id})
+		}
+		return wn
+	}
+	ways := []*osm.Way{
+		{ID: 1, Tags: osm.Tags{{Key: "highway", Value: "primary"}}, Nodes: nodeRefs(1, 2, 3)},
+		{ID: 2, Tags: osm.Tags{{Key: "amenity", Value: "bench"}}, Nodes: nodeRefs(4, 5)},
+		{ID: 3, Nodes: nodeRefs(6, 7, 6)},                                               // member of a multipolygon
+		{ID: 4, Tags: osm.Tags{{Key: "railway", Value: "rail"}}, Nodes: nodeRefs(3, 8)}, // shares node 3
+	}
+	got, err := usedNodeIDs(bytes.NewReader(pbfFile(nil, ways, nil)), map[osm.WayID][]osm.NodeID{3: nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// duplicates are removed by the node store
+	slices.Sort(got)
+	if want := []osm.NodeID{1, 2, 3, 3, 6, 6, 7, 8}; !slices.Equal(want, got) {
+		t.Errorf("Got node IDs %v, want %v", got, want)
+	}
+}
+
+func TestSetOrientation(t *testing.T) {
+	for _, tc := range []struct {
+		degrees, want int
+		wantErr       bool
+	}{
+		{0, 0, false},
+		{90, 1, false},
+		{180, 2, false},
+		{270, 3, false},
+		{360, 0, false},
+		{450, 1, false},
+		{-90, 3, false},
+		{-360, 0, false},
+		{45, 0, true},
+		{-100, 0, true},
+	} {
+		var p projection
+		err := p.setOrientation(tc.degrees, true)
+		if (err != nil) != tc.wantErr || err == nil && (p.rotation != tc.want || !p.mirror) {
+			t.Errorf("setOrientation(%d): got rotation %d, error %v, want %d, error %t", tc.degrees, p.rotation, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// where the point (10, 20) goes, by clockwise quarter turns
+	turns := []point{{10, 20}, {20, 246}, {246, 236}, {236, 10}}
+	mirrored := []point{{20, 10}, {10, 236}, {236, 246}, {246, 20}}
+	for rotation := range 4 {
+		for _, mirror := range []bool{false, true} {
+			p := projection{rotation: rotation, mirror: mirror}
+			want := turns[rotation]
+			if mirror {
+				want = mirrored[rotation]
+			}
+			if got := p.orient(point{10, 20}); got != want {
+				t.Errorf("Rotation %d, mirror %t: got %v, want %v", rotation, mirror, got, want)
+			}
+			for _, pt := range []point{{0, 0}, {256, 0}, {0, 256}, {256, 256}, {10.25, 200.5}} {
+				if got := p.unorient(p.orient(pt)); got != pt {
+					t.Errorf("Rotation %d, mirror %t: %v comes back as %v", rotation, mirror, pt, got)
+				}
+				if got := p.orient(p.unorient(pt)); got != pt {
+					t.Errorf("Rotation %d, mirror %t: %v comes back as %v", rotation, mirror, pt, got)
+				}
+			}
+		}
+	}
+}
+
+func TestOrientedCoast(t *testing.T) {
+	defer func(p projection) { proj = p }(proj)
+	// an island whose edges are on tile edges, with the land on the left
+	const near, far = (128 - 80) / 256.0, (128 - 40) / 256.0
+	corners := [][2]float64{{near, near}, {near, far}, {far, far}, {far, near}, {near, near}}
+	var coast []coastSegment
+	for i := range len(corners) - 1 {
+		coast = append(coast, coastSegment{corners[i][0], corners[i][1], corners[i+1][0], corners[i+1][1]})
+	}
+	water := ruleForClass(6)
+	for rotation := range 4 {
+		for _, mirror := range []bool{false, true} {
+			var err error
+			proj, err = newProjection("equirectangular", 0, 0, metresPerDegree/256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := proj.setOrientation(rotation*90, mirror); err != nil {
+				t.Fatal(err)
+			}
+			l := newLayers()
+			fillSea(l, coast, water)
+			for i, c := range l.tiles {
+				lat, lon := pointToCoord(point{float64(i%256) + 0.5, float64(i/256) + 0.5})
+				want := lat < near || lat > far || lon < near || lon > far
+				if got := c.rule == water; got != want {
+					t.Fatalf("Rotation %d, mirror %t: tile %d, %d at %.3f, %.3f: sea %t, want %t", rotation*90, mirror, i%256, i/256, lat, lon, got, want)
+				}
+			}
+		}
+	}
+}
+
+// captureStdout returns what fn prints.
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestLayerPriorities(t *testing.T) {
+	setRules([]rule{
+		{Name: "park", Class: 0, Type: 1, Priority: 10},
+		{Name: "grass", Class: 0, Type: 1, Priority: 10}, // same tile as park
+		{Name: "forest", Class: 4, Priority: 10},
+		{Name: "water", Class: 6, Priority: 20},
+		{Name: "houses", Class: 3, Type: 6, Priority: 5},
+	})
+	defer setRules(defaultRules())
+	r := func(name string) *rule {
+		for i := range rules {
+			if rules[i].Name == name {
+				return &rules[i]
+			}
+		}
+		panic(name)
+	}
+	l := newLayers()
+	for x, tc := range []struct {
+		first, second, want string
+	}{
+		{"forest", "park", "park"}, // equal priority, earlier rule
+		{"park", "forest", "park"},
+		{"houses", "water", "water"}, // higher priority
+		{"water", "houses", "water"},
+		{"water", "houses", "water"},
+		{"forest", "water", "water"},
+		{"forest", "water", "water"},
+		{"grass", "park", "park"}, // no conflict for the same tile
+		{"park", "grass", "park"},
+		{"water", "water", "water"},
+	} {
+		l.set(r(tc.first), x, 0)
+		l.set(r(tc.second), x, 0)
+		if got := l.tiles[xyToTile(x, 0)].rule.Name; got != tc.want {
+			t.Errorf("%s then %s: got %s, want %s", tc.first, tc.second, got, tc.want)
+		}
+	}
+	want := map[[2]string]int{
+		{"park", "forest"}:  2,
+		{"water", "houses"}: 3,
+		{"water", "forest"}: 2,
+	}
+	if !cmp.Equal(want, l.conflicts) {
+		t.Errorf("Got conflicts %v, want %v", l.conflicts, want)
+	}
+
+	s := ttd.Savegame{Tiles: make([]ttd.Tile, ttd.NumberOfTiles)}
+	out := captureStdout(t, func() { l.resolve(&s) })
+	wantOut := "Conflict: water over houses on 3 tiles\n" +
+		"Conflict: park over forest on 2 tiles\n" +
+		"Conflict: water over forest on 2 tiles\n"
+	if out != wantOut {
+		t.Errorf("Got output\n%s\nwant\n%s", out, wantOut)
+	}
+	if s.Tiles[xyToTile(0, 0)].Class != 0 || s.Tiles[xyToTile(2, 0)].Class != 6 {
+		t.Errorf("Got classes %d and %d, want the winners park and water", s.Tiles[xyToTile(0, 0)].Class, s.Tiles[xyToTile(2, 0)].Class)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// point is a position on the map in tile units, tile (x, y) covers the
+// square from (x, y) to (x+1, y+1).
+type point struct {
+	x, y float64
+}
+
+// polygonCoverage calls fn for each tile that the polygon covers, with the
+// fraction of the tile that is covered. The coverage is estimated with
+// samples x samples points in each tile. The rings can be given in any order
+// and direction, holes are handled with the even-odd rule.
+func polygonCoverage(rings [][]point, samples int, fn func(x, y int, coverage float64)) {
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, r := range rings {
+		for _, p := range r {
+			minY = min(minY, p.y)
+			maxY = max(maxY, p.y)
+		}
+	}
+	if minY > maxY {
+		return
+	}
+	n := float64(samples)
+	var counts [256]int
+	flush := func(y int) {
+		for x, c := range counts {
+			if c > 0 {
+				fn(x, y, float64(c)/(n*n))
+				counts[x] = 0
+			}
+		}
+	}
+	var xs []float64
+	from := max(0, int(math.Floor(minY*n)))
+	to := min(256*samples-1, int(math.Ceil(maxY*n)))
+	for row := from; row <= to; row++ {
+		if row > from && row%samples == 0 {
+			flush(row/samples - 1)
+		}
+		cy := (float64(row) + 0.5) / n
+		xs = xs[:0]
+		for _, r := range rings {
+			for i := range r {
+				a, b := r[i], r[(i+1)%len(r)]
+				if (a.y <= cy) != (b.y <= cy) {
+					xs = append(xs, a.x+(cy-a.y)*(b.x-a.x)/(b.y-a.y))
+				}
+			}
+		}
+		slices.Sort(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			first := max(0, int(math.Ceil(xs[i]*n-0.5)))
+			last := min(256*samples-1, int(math.Ceil(xs[i+1]*n-0.5))-1)
+			for col := first; col <= last; col++ {
+				counts[col/samples]++
+			}
+		}
+	}
+	if to >= from {
+		flush(to / samples)
+	}
+}
+
+// fillPolygon calls fn for each tile whose centre is inside the polygon.
+func fillPolygon(rings [][]point, fn func(x, y int)) {
+	polygonCoverage(rings, 1, func(x, y int, _ float64) {
+		fn(x, y)
+	})
+}
+
+// centroid returns the average of the points of the outer ring.
+func centroid(ring []point) point {
+	var c point
+	for _, p := range ring {
+		c.x += p.x / float64(len(ring))
+		c.y += p.y / float64(len(ring))
+	}
+	return c
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const metresPerDegree = 111320 // along a meridian, on a spherical earth
+
+// projection maps coordinates to the map. The projected coordinates u and v
+// are in degrees along the equator and along a meridian.
+type projection struct {
+	mercator       bool
+	cosLat         float64 // for equirectangular, at the centre of the map
+	maxU, maxV     float64 // east and north edges of the map
+	degreesPerTile float64
+	rotation       int  // clockwise quarter turns, as seen in the game
+	mirror         bool // left-right, as seen in the game
+}
+
+var proj projection
+
+func (p projection) forward(lat, lon float64) (u, v float64) {
+	if p.mercator {
+		return lon, math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)) * 180 / math.Pi
+	}
+	return lon * p.cosLat, lat
+}
+
+func (p projection) inverse(u, v float64) (lat, lon float64) {
+	if p.mercator {
+		return (2*math.Atan(math.Exp(v*math.Pi/180)) - math.Pi/2) * 180 / math.Pi, u
+	}
+	return v, u / p.cosLat
+}
+
+// metresPerTile returns the size of a tile at the centre of the map.
+func (p projection) metresPerTile() float64 {
+	if p.mercator {
+		return p.degreesPerTile * metresPerDegree * p.cosLat
+	}
+	return p.degreesPerTile * metresPerDegree
+}
+
+// newProjection creates a projection of the given type that is centred on
+// the given coordinates, with square tiles of the given size in metres.
+func newProjection(name string, lat, lon, metresPerTile float64) (projection, error) {
+	p := projection{cosLat: math.Cos(lat * math.Pi / 180)}
+	switch name {
+	case "equirectangular":
+		p.degreesPerTile = metresPerTile / metresPerDegree
+	case "mercator":
+		p.mercator = true
+		p.degreesPerTile = metresPerTile / metresPerDegree / p.cosLat
+	default:
+		return p, fmt.Errorf("unknown projection %q", name)
+	}
+	u, v := p.forward(lat, lon)
+	p.maxU = u + 128*p.degreesPerTile
+	p.maxV = v + 128*p.degreesPerTile
+	return p, nil
+}
+
+// newBBoxProjection creates a projection that fits the bounding box
+// "minlon,minlat,maxlon,maxlat" in the map, keeping the real proportions.
+func newBBoxProjection(name, bbox string) (projection, error) {
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return projection{}, fmt.Errorf("bounding box %q should be minlon,minlat,maxlon,maxlat", bbox)
+	}
+	var c [4]float64
+	for i, part := range parts {
+		var err error
+		c[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return projection{}, err
+		}
+	}
+	minLon, minLat, maxLon, maxLat := c[0], c[1], c[2], c[3]
+	if minLon >= maxLon || minLat >= maxLat {
+		return projection{}, fmt.Errorf("bounding box %q is empty", bbox)
+	}
+
+	p, err := newProjection(name, (minLat+maxLat)/2, (minLon+maxLon)/2, 1)
+	if err != nil {
+		return p, err
+	}
+	u1, v1 := p.forward(minLat, minLon)
+	u2, v2 := p.forward(maxLat, maxLon)
+	p.degreesPerTile = max(u2-u1, v2-v1) / 256
+	p.maxU = (u1+u2)/2 + 128*p.degreesPerTile
+	p.maxV = (v1+v2)/2 + 128*p.degreesPerTile
+	return p, nil
+}
+
+// In the game the x axis points down-left and the y axis down-right, so
+// swapping them mirrors the map left-right, and (x, y) -> (y, 256-x) turns it
+// clockwise.
+
+func (p projection) orient(pt point) point {
+	if p.mirror {
+		pt.x, pt.y = pt.y, pt.x
+	}
+	for range p.rotation {
+		pt.x, pt.y = pt.y, 256-pt.x
+	}
+	return pt
+}
+
+func (p projection) unorient(pt point) point {
+	for range p.rotation {
+		pt.x, pt.y = 256-pt.y, pt.x
+	}
+	if p.mirror {
+		pt.x, pt.y = pt.y, pt.x
+	}
+	return pt
+}
+
+// setOrientation sets the rotation in degrees, which has to be a multiple of 90.
+func (p *projection) setOrientation(degrees int, mirror bool) error {
+	if degrees%90 != 0 {
+		return fmt.Errorf("rotation %d is not a multiple of 90 degrees", degrees)
+	}
+	p.rotation = (degrees/90%4 + 4) % 4
+	p.mirror = mirror
+	return nil
+}
+
+// coordToPoint projects coordinates to the map. Without rotation or
+// mirroring the map is east-west and north-south inverted, so the east edge
+// is at x = 0 and the north edge, which is up-left in the game, at y = 0.
+func coordToPoint(lat, lon float64) point {
+	u, v := proj.forward(lat, lon)
+	return proj.orient(point{(proj.maxU - u) / proj.degreesPerTile, (proj.maxV - v) / proj.degreesPerTile})
+}
+
+// pointToCoord is the inverse of coordToPoint.
+func pointToCoord(p point) (lat, lon float64) {
+	p = proj.unorient(p)
+	return proj.inverse(proj.maxU-p.x*proj.degreesPerTile, proj.maxV-p.y*proj.degreesPerTile)
+}
+
+func inBBox(lat, lon float64) bool {
+	p := coordToPoint(lat, lon)
+	return p.x >= 0 && p.y >= 0 && p.x < 256 && p.y < 256
+}
+
+func coordToXY(lat, lon float64) (int, int) {
+	p := coordToPoint(lat, lon)
+	return int(math.Floor(p.x)), int(math.Floor(p.y))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"io"
+	"slices"
+
+	"github.com/paulmach/osm"
+	"github.com/paulmach/osm/osmpbf"
+)
+
+type multipolygon struct {
+	rule         *rule
+	outer, inner []osm.WayID
+}
+
+// readMultipolygons reads the multipolygon relations that describe areas we
+// can convert. Relations come after the ways in PBF files, so they have to be
+// read in a separate pass before the ways.
+func readMultipolygons(in io.Reader) ([]multipolygon, error) {
+	scanner := osmpbf.New(context.Background(), in, 3)
+	scanner.SkipNodes = true
+	scanner.SkipWays = true
+	defer scanner.Close()
+
+	var multipolygons []multipolygon
+	for scanner.Scan() {
+		r, ok := scanner.Object().(*osm.Relation)
+		if !ok || r.Tags.Find("type") != "multipolygon" {
+			continue
+		}
+		rule := matchRule(r.Tags)
+		if rule == nil || !rule.isArea() {
+			continue
+		}
+		m := multipolygon{rule: rule}
+		for _, member := range r.Members {
+			if member.Type != osm.TypeWay {
+				continue
+			}
+			if member.Role == "inner" {
+				m.inner = append(m.inner, osm.WayID(member.Ref))
+			} else {
+				m.outer = append(m.outer, osm.WayID(member.Ref))
+			}
+		}
+		multipolygons = append(multipolygons, m)
+	}
+	return multipolygons, scanner.Err()
+}
+
+// joinRings joins ways that share end nodes into closed rings. Ways that
+// can't be closed, e.g. because the extract doesn't contain all members, are
+// dropped and counted.
+func joinRings(ways [][]osm.NodeID) ([][]osm.NodeID, int) {
+	var rings [][]osm.NodeID
+	dropped := 0
+	remaining := slices.Clone(ways)
+	for len(remaining) > 0 {
+		ring := slices.Clone(remaining[0])
+		remaining = remaining[1:]
+		for ring[0] != ring[len(ring)-1] {
+			last := ring[len(ring)-1]
+			found := false
+			for i, w := range remaining {
+				if w[0] == last {
+					ring = append(ring, w[1:]...)
+				} else if w[len(w)-1] == last {
+					r := slices.Clone(w)
+					slices.Reverse(r)
+					ring = append(ring, r[1:]...)
+				} else {
+					continue
+				}
+				remaining = slices.Delete(remaining, i, i+1)
+				found = true
+				break
+			}
+			if !found {
+				break
+			}
+		}
+		if len(ring) >= 4 && ring[0] == ring[len(ring)-1] {
+			rings = append(rings, ring)
+		} else {
+			dropped++
+		}
+	}
+	return rings, dropped
+}
+
+// rings returns the outer and inner rings of the multipolygon in map
+// coordinates. Missing member ways and nodes make the affected rings be
+// dropped and counted.
+func (m multipolygon) rings(ways map[osm.WayID][]osm.NodeID, nodes nodeStore) ([][]point, int) {
+	var out [][]point
+	dropped := 0
+	for _, ids := range [][]osm.WayID{m.outer, m.inner} {
+		var members [][]osm.NodeID
+		for _, id := range ids {
+			if w, ok := ways[id]; ok && len(w) >= 2 {
+				members = append(members, w)
+			} else {
+				dropped++
+			}
+		}
+		rings, d := joinRings(members)
+		dropped += d
+	ring:
+		for _, r := range rings {
+			var points []point
+			for _, id := range r {
+				n, ok := nodes.get(id)
+				if !ok {
+					dropped++
+					continue ring
+				}
+				points = append(points, coordToPoint(n.lat, n.lon))
+			}
+			out = append(out, points)
+		}
+	}
+	return out, dropped
+}
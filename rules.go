@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"osm2ttd/ttd"
+	"path"
+	"strings"
+
+	"github.com/paulmach/osm"
+)
+
+// predicate matches elements that have a tag with a matching key and value.
+// Keys and values can use wildcards like "*" and alternatives separated by
+// "|", an empty value matches any value. With Not set it matches elements
+// that don't have such a tag.
+type predicate struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Not   bool   `json:"not,omitempty"`
+}
+
+// rule converts the elements matching all its predicates to tiles. Ways are
+// drawn as lines, closed ways and multipolygons are filled, except for roads
+// and rails which are always lines, and nodes become a single tile.
+type rule struct {
+	Name     string      `json:"name"`
+	Match    []predicate `json:"match"`
+	Class    uint8       `json:"class"`              // 0 = clear, 1 = rail, 2 = road, 3 = house, 4 = trees, 6 = water, 8 = industry
+	Type     uint8       `json:"type,omitempty"`     // for houses and clear land, road pieces and rail tracks are calculated
+	Industry string      `json:"industry,omitempty"` // for industries, and clear land or trees that also get an industry, like "forest"
+	Owner    *uint8      `json:"owner,omitempty"`    // default is no owner, water for water, or the railowner flag for rail
+	Priority int         `json:"priority"`           // the rule with the highest priority is used
+	Sea      bool        `json:"sea,omitempty"`      // coastline with the sea on the right
+	Zone     string      `json:"zone,omitempty"`     // tropic zone of areas in the sub-tropical climate, "desert" or "rainforest"
+	order    int         // position in the rules, for breaking ties
+}
+
+var rules []rule
+
+func setRules(rs []rule) {
+	for i := range rs {
+		rs[i].order = i
+	}
+	rules = rs
+}
+
+func defaultRules() []rule {
+	return []rule{
+		{Name: "isolated dwelling", Match: []predicate{{Key: "building", Value: "isolated_dwelling"}}, Class: 3, Type: 0x18, Priority: 31},
+		{Name: "building", Match: []predicate{{Key: "building"}}, Class: 3, Type: 0x06, Priority: 30},
+		{Name: "railway", Match: []predicate{{Key: "railway", Value: "rail"}}, Class: 1, Priority: 50},
+		{Name: "road", Match: []predicate{{Key: "highway", Value: strings.ReplaceAll(*roadTags, ",", "|")}}, Class: 2, Priority: 40},
+		{Name: "water", Match: []predicate{{Key: "natural", Value: "water"}}, Class: 6, Priority: 10},
+		{Name: "riverbank", Match: []predicate{{Key: "waterway", Value: "riverbank"}}, Class: 6, Priority: 10},
+		{Name: "river", Match: []predicate{{Key: "waterway", Value: "river"}}, Class: 6, Priority: 10},
+		{Name: "quarry", Match: []predicate{{Key: "landuse", Value: "quarry"}}, Class: 8, Industry: "coal mine", Priority: 20},
+		{Name: "forest", Match: []predicate{{Key: "landuse", Value: "forest"}}, Class: 4, Industry: "forest", Priority: 20},
+		{Name: "wood", Match: []predicate{{Key: "natural", Value: "wood"}}, Class: 4, Zone: "rainforest", Priority: 20},
+		{Name: "farmland", Match: []predicate{{Key: "landuse", Value: "farmland"}}, Class: 0, Type: ttd.ClearFields<<2 | 3, Industry: "farm", Priority: 20},
+		{Name: "meadow", Match: []predicate{{Key: "landuse", Value: "meadow"}}, Class: 0, Type: ttd.ClearFields<<2 | 3, Priority: 20},
+		{Name: "works", Match: []predicate{{Key: "man_made", Value: "works"}}, Class: 8, Industry: "factory", Priority: 20},
+		{Name: "power plant", Match: []predicate{{Key: "power", Value: "plant"}}, Class: 8, Industry: "power station", Priority: 20},
+		{Name: "refinery", Match: []predicate{{Key: "industrial", Value: "refinery|oil_refinery"}}, Class: 8, Industry: "oil refinery", Priority: 20},
+		{Name: "scree", Match: []predicate{{Key: "natural", Value: "scree|bare_rock"}}, Class: 0, Type: ttd.ClearRocks<<2 | 3, Priority: 15},
+		{Name: "sand", Match: []predicate{{Key: "natural", Value: "sand|desert"}}, Class: 0, Type: ttd.ClearDesert<<2 | 3, Zone: "desert", Priority: 15},
+		{Name: "glacier", Match: []predicate{{Key: "natural", Value: "glacier"}}, Class: 0, Type: ttd.ClearSnow<<2 | 3, Priority: 15},
+		{Name: "rough land", Match: []predicate{{Key: "natural|landuse", Value: "heath|brownfield"}}, Class: 0, Type: ttd.ClearRough<<2 | 3, Priority: 15},
+		{Name: "coastline", Match: []predicate{{Key: "natural", Value: "coastline"}}, Class: 6, Priority: 10, Sea: true},
+	}
+}
+
+// patternMatch matches a tag key or value against alternative patterns.
+// path.Match doesn't let wildcards match "/", which is an ordinary character
+// in tags, so it is swapped for a character that tags don't contain.
+func patternMatch(pattern, s string) bool {
+	s = strings.ReplaceAll(s, "/", "\x00")
+	for _, p := range strings.Split(pattern, "|") {
+		if ok, _ := path.Match(strings.ReplaceAll(p, "/", "\x00"), s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p predicate) matches(tags osm.Tags) bool {
+	for _, t := range tags {
+		if patternMatch(p.Key, t.Key) && (p.Value == "" || patternMatch(p.Value, t.Value)) {
+			return !p.Not
+		}
+	}
+	return p.Not
+}
+
+func (r *rule) matches(tags osm.Tags) bool {
+	for _, p := range r.Match {
+		if !p.matches(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRule returns the matching rule with the highest priority, the first
+// one if there are several, or nil if no rule matches.
+func matchRule(tags osm.Tags) *rule {
+	var best *rule
+	for i := range rules {
+		if rules[i].matches(tags) && (best == nil || rules[i].wins(best)) {
+			best = &rules[i]
+		}
+	}
+	return best
+}
+
+// isArea returns whether closed ways and multipolygons matching the rule are
+// filled.
+func (r *rule) isArea() bool {
+	return r.Class != 1 && r.Class != 2 && !r.Sea
+}
+
+// isLine returns whether the rule is for roads or rails, whose tiles connect
+// to each other.
+func (r *rule) isLine() bool {
+	return r.Class == 1 || r.Class == 2
+}
+
+func (r *rule) owner() uint8 {
+	if r.Owner != nil {
+		return *r.Owner
+	}
+	if r.Class == 6 {
+		return 0x11 // water
+	}
+	if r.Class == 1 && *railOwner >= 0 {
+		return uint8(*railOwner)
+	}
+	return 0x10 // no owner
+}
+
+// setTile sets a map tile to the rule's tile. Road pieces and rail tracks
+// are set by the caller.
+func (r *rule) setTile(s *ttd.Savegame, x, y int) {
+	t := &s.Tiles[xyToTile(x, y)]
+	*t = ttd.Tile{Class: r.Class, Type: r.Type, Owner: r.owner(), Height: t.Height}
+	switch r.Class {
+	case 0:
+		setGround(s, x, y)
+	case 1:
+		t.Ground = 1 // grass
+	case 4:
+		setTrees(t, x, y, s.LandscapeType, tropicZone(s, x, y))
+	case 6:
+		t.Height = 0 // sea level
+	}
+}
+
+func loadRules(filename string) ([]rule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rs []rule
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, r := range rs {
+		if r.Class != 0 && r.Class != 1 && r.Class != 2 && r.Class != 3 && r.Class != 4 && r.Class != 6 && r.Class != 8 {
+			return nil, fmt.Errorf("%s: rule %q: unsupported tile class %d", filename, r.Name, r.Class)
+		}
+		if _, ok := industryKinds[r.Industry]; !ok && (r.Class == 8 || r.Industry != "") {
+			return nil, fmt.Errorf("%s: rule %q: unknown industry %q", filename, r.Name, r.Industry)
+		}
+		if _, ok := tropicZones[r.Zone]; !ok && r.Zone != "" {
+			return nil, fmt.Errorf("%s: rule %q: unknown tropic zone %q", filename, r.Name, r.Zone)
+		}
+		if r.Industry != "" && r.Class != 0 && r.Class != 4 && r.Class != 8 {
+			return nil, fmt.Errorf("%s: rule %q: only industries, clear land and trees can have an industry", filename, r.Name)
+		}
+		if len(r.Match) == 0 {
+			return nil, fmt.Errorf("%s: rule %q doesn't match any tags", filename, r.Name)
+		}
+		for _, p := range r.Match {
+			for _, pattern := range strings.Split(p.Key+"|"+p.Value, "|") {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("%s: rule %q: bad pattern %q", filename, r.Name, pattern)
+				}
+			}
+		}
+	}
+	return rs, nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"osm2ttd/ttd"
+	"slices"
+
+	"github.com/paulmach/osm"
+)
+
+// structure is a road or rail way tagged as a bridge or a tunnel. Whether it
+// becomes one depends on what is under it, so it is converted after the other
+// features.
+type structure struct {
+	way    osm.WayID
+	rule   *rule
+	tunnel bool
+	path   []tileXY
+}
+
+// bridge is a bridge from head to head.
+type bridge struct {
+	structure
+	under []ttd.Tile // the tiles before the bridge was built
+}
+
+// tunnel is a tunnel from entrance to entrance.
+type tunnel struct {
+	structure
+}
+
+const (
+	bridgeType      = 0  // wooden
+	maxBridgeLength = 16 // tiles between the heads
+)
+
+func isBridge(tags osm.Tags) bool {
+	v := tags.Find("bridge")
+	return v != "" && v != "no"
+}
+
+func isTunnel(tags osm.Tags) bool {
+	v := tags.Find("tunnel")
+	return v != "" && v != "no"
+}
+
+// isStraight returns whether the path goes along one axis.
+func isStraight(path []tileXY) bool {
+	first, last := path[0], path[len(path)-1]
+	return (first.x == last.x || first.y == last.y) && len(path) == abs(last.x-first.x)+abs(last.y-first.y)+1
+}
+
+// transport returns the transport type of tunnels and bridges for a rule.
+func (r *rule) transport() uint8 {
+	if r.Class == 1 {
+		return 0 // rail
+	}
+	return 1 // road
+}
+
+// planStructures decides which structures become bridges or tunnels. A
+// bridge is built over the water on its way, between the land tiles on both
+// sides, and a tunnel through terrain that is higher than its ends. The
+// other structures are added as normal roads or rails, or dropped if they
+// cross water but can't be bridges.
+func (l *layers) planStructures(s *ttd.Savegame) {
+	for _, st := range l.structures {
+		path := st.path
+		if st.tunnel {
+			ends := max(s.Tiles[xyToTile(path[0].x, path[0].y)].Height, s.Tiles[xyToTile(path[len(path)-1].x, path[len(path)-1].y)].Height)
+			raised := slices.ContainsFunc(path, func(t tileXY) bool {
+				return s.Tiles[xyToTile(t.x, t.y)].Height > ends
+			})
+			if !raised {
+				addPath(l, st.rule, path, 0, len(path))
+			} else if len(path) < 3 || !isStraight(path) {
+				fmt.Printf("Dropped tunnel of way %d: not straight\n", st.way)
+			} else {
+				l.tunnels = append(l.tunnels, tunnel{st})
+			}
+			continue
+		}
+
+		first, last := -1, -1
+		for i, t := range path {
+			if l.isWater(t) {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if first < 0 {
+			addPath(l, st.rule, path, 0, len(path))
+			continue
+		}
+		// the heads are on the land on both sides of the water, and if the
+		// way isn't straight, the bridge goes straight from one side
+		var bridged []tileXY
+		var a, b int
+		ok := false
+		if first > 0 {
+			bridged, a, b, ok = l.straightBridge(path, first-1)
+		}
+		if !ok && last < len(path)-1 {
+			reversed := slices.Clone(path)
+			slices.Reverse(reversed)
+			bridged, a, b, ok = l.straightBridge(reversed, len(path)-last-2)
+		}
+		if !ok {
+			fmt.Printf("Dropped bridge of way %d: no land for a straight bridge of at most %d tiles\n", st.way, maxBridgeLength)
+			continue
+		}
+		path = bridged
+		addPath(l, st.rule, path, 0, a)
+		addPath(l, st.rule, path, b+1, len(path))
+		st.path = path[a : b+1]
+		l.bridges = append(l.bridges, bridge{structure: st})
+	}
+}
+
+func (l *layers) isWater(t tileXY) bool {
+	c := l.tiles[xyToTile(t.x, t.y)]
+	return c.rule != nil && c.rule.Class == 6
+}
+
+// straightBridge finds a bridge that starts at path[from] and goes straight
+// over the water towards path[from+1]. It returns the path with the bridge,
+// which is connected to the rest of the way if the way doesn't continue
+// straight after the water, and the indices of the heads on it.
+func (l *layers) straightBridge(path []tileXY, from int) ([]tileXY, int, int, bool) {
+	start, next := path[from], path[from+1]
+	dx, dy := next.x-start.x, next.y-start.y
+	inMap := func(t tileXY) bool {
+		return t.x >= 0 && t.y >= 0 && t.x < 256 && t.y < 256
+	}
+	for n := 2; n <= maxBridgeLength+1; n++ {
+		end := tileXY{start.x + n*dx, start.y + n*dy}
+		if !inMap(end) {
+			return nil, 0, 0, false
+		}
+		if l.isWater(end) {
+			continue
+		}
+		bridged := slices.Clone(path[:from])
+		line(start.x, start.y, end.x, end.y, func(x, y int) {
+			bridged = append(bridged, tileXY{x, y})
+		})
+		if i := slices.Index(path[from+1:], end); i >= 0 {
+			return append(bridged, path[from+2+i:]...), from, from + n, true
+		}
+		// join the way from the tile after the head
+		after := tileXY{end.x + dx, end.y + dy}
+		if !inMap(after) || l.isWater(after) {
+			return nil, 0, 0, false
+		}
+		for i := from + 2; i < len(path); i++ {
+			p := path[i]
+			if abs(p.x-after.x)+abs(p.y-after.y) > 2 || l.isWater(p) {
+				continue
+			}
+			ok := true
+			var join []tileXY
+			line(after.x, after.y, p.x, p.y, func(x, y int) {
+				ok = ok && !l.isWater(tileXY{x, y})
+				join = append(join, tileXY{x, y})
+			})
+			if ok {
+				return slices.Concat(bridged, join, path[i+1:]), from, from + n, true
+			}
+		}
+		return nil, 0, 0, false
+	}
+	return nil, 0, 0, false
+}
+
+// bridgePiece returns the piece of a bridge middle part by its distance to
+// the northern and southern heads, like TTD does.
+func bridgePiece(north, south int) uint8 {
+	switch {
+	case north == 1:
+		return 0
+	case south == 1:
+		return 1
+	case north < south:
+		return 2 + uint8(north&1)
+	case north > south:
+		return 3 - uint8(south&1)
+	default:
+		return 4 + uint8(north&1)
+	}
+}
+
+// buildBridges puts the planned bridges on the map, over whatever is there.
+func (l *layers) buildBridges(s *ttd.Savegame) {
+	for i := range l.bridges {
+		b := &l.bridges[i]
+		path := b.path
+		if path[0].x > path[len(path)-1].x || path[0].y > path[len(path)-1].y {
+			path = slices.Clone(path)
+			slices.Reverse(path) // from north to south
+		}
+		axis := uint8(0)
+		if path[0].x == path[1].x {
+			axis = 1
+		}
+		b.under = nil
+		for j, p := range path {
+			t := &s.Tiles[xyToTile(p.x, p.y)]
+			b.under = append(b.under, *t)
+			bt := ttd.Tile{Class: 9, Height: t.Height, Owner: b.rule.owner(), Transport: b.rule.transport(), Axis: axis, BridgeType: bridgeType}
+			switch {
+			case j == 0:
+				bt.BridgeEnd = 1
+			case j == len(path)-1:
+				bt.BridgeEnd = 2
+			default:
+				bt.BridgePiece = bridgePiece(j, len(path)-1-j)
+				switch t.Class {
+				case 6:
+					bt.UnderBridge = ttd.UnderBridgeWater
+				case 1:
+					bt.UnderBridge = ttd.UnderBridgeRail
+				case 2:
+					bt.UnderBridge = ttd.UnderBridgeRoad
+				}
+			}
+			*t = bt
+		}
+		b.path = path
+	}
+}
+
+// checkBridges removes the bridges whose heads aren't at the same height or
+// that would be lower than the terrain under them, now that the terrain is
+// final.
+func (l *layers) checkBridges(s *ttd.Savegame) {
+	built := 0
+	for _, b := range l.bridges {
+		head := tileHeight(s, b.path[0])
+		reason := ""
+		if tileHeight(s, b.path[len(b.path)-1]) != head {
+			reason = "heads at different heights"
+		}
+		for _, p := range b.path[1 : len(b.path)-1] {
+			if highestCorner(s, p) > head {
+				reason = "terrain higher than the bridge"
+			}
+		}
+		if reason == "" {
+			built++
+			continue
+		}
+		fmt.Printf("Dropped bridge of way %d: %s\n", b.way, reason)
+		for j, p := range b.path {
+			t := &s.Tiles[xyToTile(p.x, p.y)]
+			height := t.Height
+			if j == 0 || j == len(b.path)-1 {
+				*t = ttd.Tile{Height: height, Owner: 0x10, Type: 0x03} // grass
+			} else {
+				*t = b.under[j]
+				t.Height = height
+			}
+		}
+	}
+	fmt.Printf("Bridges: %d built\n", built)
+}
+
+func tileHeight(s *ttd.Savegame, t tileXY) uint8 {
+	return s.Tiles[xyToTile(t.x, t.y)].Height
+}
+
+func highestCorner(s *ttd.Savegame, t tileXY) uint8 {
+	h := uint8(0)
+	for _, c := range tileCorners(t.x, t.y) {
+		h = max(h, s.Tiles[c].Height)
+	}
+	return h
+}
+
+func lowestCorner(s *ttd.Savegame, t tileXY) uint8 {
+	h := uint8(15)
+	for _, c := range tileCorners(t.x, t.y) {
+		h = min(h, s.Tiles[c].Height)
+	}
+	return h
+}
+
+// Directions of tunnels, towards the edge of a tile
+const (
+	dirNE = iota // x - 1
+	dirSE        // y + 1
+	dirSW        // x + 1
+	dirNW        // y - 1
+)
+
+// direction returns the direction from one tile to another on the same axis.
+func direction(from, to tileXY) uint8 {
+	switch {
+	case to.x < from.x:
+		return dirNE
+	case to.y > from.y:
+		return dirSE
+	case to.x > from.x:
+		return dirSW
+	default:
+		return dirNW
+	}
+}
+
+// edgeCorners returns the heights of the two corners on the edge of a tile in
+// a direction.
+func edgeCorners(s *ttd.Savegame, t tileXY, dir uint8) (uint8, uint8) {
+	h := func(x, y int) uint8 {
+		return s.Tiles[xyToTile(x, y)].Height
+	}
+	switch dir {
+	case dirNE:
+		return h(t.x, t.y), h(t.x, t.y+1)
+	case dirSE:
+		return h(t.x, t.y+1), h(t.x+1, t.y+1)
+	case dirSW:
+		return h(t.x+1, t.y), h(t.x+1, t.y+1)
+	default:
+		return h(t.x, t.y), h(t.x+1, t.y)
+	}
+}
+
+// tunnelEntranceHeight returns the height of a tunnel entrance, which has to
+// be on a slope that rises by one level towards the tunnel, or false if the
+// tile isn't such a slope.
+func tunnelEntranceHeight(s *ttd.Savegame, t tileXY, dir uint8) (uint8, bool) {
+	if t.x > 254 || t.y > 254 {
+		return 0, false
+	}
+	low1, low2 := edgeCorners(s, t, (dir+2)%4)
+	high1, high2 := edgeCorners(s, t, dir)
+	return low1, low1 == low2 && high1 == high2 && high1 == low1+1
+}
+
+// buildTunnels puts the planned tunnels on the map, if the terrain is
+// suitable now that it is final: both entrances are on slopes at the same
+// height, and the terrain between them is above the tunnel.
+func (l *layers) buildTunnels(s *ttd.Savegame) {
+	built := 0
+	for _, tn := range l.tunnels {
+		a, b := tn.path[0], tn.path[len(tn.path)-1]
+		dir := direction(a, b)
+		h, ok := tunnelEntranceHeight(s, a, dir)
+		hb, okb := tunnelEntranceHeight(s, b, (dir+2)%4)
+		reason := ""
+		switch {
+		case !ok || !okb:
+			reason = "entrances not on slopes towards the tunnel"
+		case h != hb:
+			reason = "entrances at different heights"
+		}
+		for _, p := range tn.path[1 : len(tn.path)-1] {
+			if reason == "" && lowestCorner(s, p) <= h {
+				reason = "terrain not above the tunnel"
+			}
+		}
+		if reason != "" {
+			fmt.Printf("Dropped tunnel of way %d: %s\n", tn.way, reason)
+			continue
+		}
+		for _, e := range []struct {
+			t   tileXY
+			dir uint8
+		}{{a, dir}, {b, (dir + 2) % 4}} {
+			t := &s.Tiles[xyToTile(e.t.x, e.t.y)]
+			*t = ttd.Tile{Class: 9, Height: t.Height, Owner: tn.rule.owner(), Tunnel: true, Transport: tn.rule.transport(), Direction: e.dir}
+		}
+		built++
+	}
+	fmt.Printf("Tunnels: %d built\n", built)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"osm2ttd/ttd"
+)
+
+// The height of a tile is the height of its north corner, so the corners of
+// tile (x, y) are the heights of tiles (x, y), (x+1, y), (x, y+1) and
+// (x+1, y+1).
+
+func tileCorners(x, y int) []int {
+	var corners []int
+	for _, c := range [][2]int{{x, y}, {x + 1, y}, {x, y + 1}, {x + 1, y + 1}} {
+		if c[0] < 256 && c[1] < 256 {
+			corners = append(corners, xyToTile(c[0], c[1]))
+		}
+	}
+	return corners
+}
+
+func needsFlatTile(t ttd.Tile) bool {
+	if t.Class == 9 {
+		return !t.Tunnel && t.BridgeEnd != 0 // bridge head
+	}
+	return t.Class == 1 || t.Class == 2 || t.Class == 3 || t.Class == 6 || t.Class == 8 // rail, road, building, water, industry
+}
+
+// flattenTiles lowers all corners of the given tiles to their lowest corner.
+func flattenTiles(s *ttd.Savegame, tiles []int) bool {
+	changed := false
+	for _, i := range tiles {
+		corners := tileCorners(i%256, i/256)
+		lowest := s.Tiles[corners[0]].Height
+		for _, c := range corners {
+			lowest = min(lowest, s.Tiles[c].Height)
+		}
+		for _, c := range corners {
+			if s.Tiles[c].Height != lowest {
+				s.Tiles[c].Height = lowest
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// smoothTerrain lowers tiles until no neighbouring corners differ by more than
+// one level, including diagonal ones, so that no tile is steeper than one
+// level. A forward and a backward pass are enough, as each tile can only be
+// limited by its lowest neighbour.
+func smoothTerrain(s *ttd.Savegame) bool {
+	changed := false
+	limit := func(x, y, nx, ny int) {
+		if nx < 0 || ny < 0 || nx > 255 || ny > 255 {
+			return
+		}
+		i, neighbour := xyToTile(x, y), xyToTile(nx, ny)
+		if s.Tiles[i].Height > s.Tiles[neighbour].Height+1 {
+			s.Tiles[i].Height = s.Tiles[neighbour].Height + 1
+			changed = true
+		}
+	}
+	for y := range 256 {
+		for x := range 256 {
+			limit(x, y, x-1, y)
+			limit(x, y, x-1, y-1)
+			limit(x, y, x, y-1)
+			limit(x, y, x+1, y-1)
+		}
+	}
+	for y := 255; y >= 0; y-- {
+		for x := 255; x >= 0; x-- {
+			limit(x, y, x+1, y)
+			limit(x, y, x+1, y+1)
+			limit(x, y, x, y+1)
+			limit(x, y, x-1, y+1)
+		}
+	}
+	return changed
+}
+
+// normaliseTerrain makes the heights valid for TTD and flattens the tiles
+// under roads, buildings and town centres. It returns the number of tiles
+// whose height was changed.
+func normaliseTerrain(s *ttd.Savegame) int {
+	before := make([]uint8, len(s.Tiles))
+	var flat []int
+	for i, t := range s.Tiles {
+		before[i] = t.Height
+		if needsFlatTile(t) {
+			flat = append(flat, i)
+		}
+	}
+	for _, t := range s.Towns {
+		flat = append(flat, xyToTile(int(t.X), int(t.Y)))
+	}
+
+	// both steps only lower tiles, so this terminates
+	for {
+		flattened := flattenTiles(s, flat)
+		smoothed := smoothTerrain(s)
+		if !flattened && !smoothed {
+			break
+		}
+	}
+
+	changed := 0
+	for i, t := range s.Tiles {
+		if t.Height != before[i] {
+			changed++
+		}
+	}
+	return changed
+}
@@ -0,0 +1,53 @@
+package main
+
+import "osm2ttd/ttd"
+
+// treeSpecies are the first tree species and the number of species in each
+// climate. The tropical ones are the rainforest trees.
+var treeSpecies = map[uint8][2]uint8{
+	0: {0, 12},
+	1: {12, 8},
+	2: {20, 7},
+	3: {32, 9},
+}
+
+// tropicalTreeSpecies are the tree species in each zone of the tropics.
+var tropicalTreeSpecies = map[uint8][2]uint8{
+	ttd.TropicNormal:     {28, 4},
+	ttd.TropicDesert:     {27, 1}, // cactus
+	ttd.TropicRainforest: {20, 7},
+}
+
+// tileHash returns a pseudo-random number for a tile, so that the same map
+// gets the same trees every time.
+func tileHash(x, y int) uint32 {
+	h := uint32(x)*0x9e3779b1 ^ uint32(y)*0x85ebca77
+	h ^= h >> 15
+	h *= 0x2c1b3c6d
+	h ^= h >> 12
+	return h
+}
+
+// setTrees plants trees of random species on a tile, on average treeDensity
+// of them, from the species of the climate and tropic zone. Tiles that get no
+// trees are grass.
+func setTrees(t *ttd.Tile, x, y int, climate, zone uint8) {
+	h := tileHash(x, y)
+	n := int(*treeDensity)
+	if float64(h%1000)/1000 < *treeDensity-float64(n) {
+		n++
+	}
+	n = min(n, 4)
+	if n == 0 {
+		*t = ttd.Tile{Height: t.Height, Owner: 0x10, Type: 0x03} // grass
+		return
+	}
+	species := treeSpecies[climate]
+	if climate == 2 {
+		species = tropicalTreeSpecies[zone]
+	}
+	t.Type = species[0] + uint8(h>>10%uint32(species[1]))
+	t.Trees = uint8(n)
+	t.Growth = 3  // grown
+	t.Density = 3 // full grass under the trees
+}
@@ -7,8 +7,15 @@ const (
 	townPlaceholder   = 0x5e - 6
 	firstCustomTextID = 0x7c00 // it seems values outside 0x7c00 - 0x7df4 are special values, such as random names for towns
 	placeholder1      = 49*6 + 0xc*8
-	placeholder2      = 0x8e*0xfa + 0x36*0x5a
-	placeholder3      = 0x80 * 0x352
+	stationSize       = 0x8e
+	maxStations       = 0xfa
+	industrySize      = 0x36
+	maxIndustries     = 0x5a
+	townPointerBase   = 0x264                       // TTD's pointer to the first town
+	orderPointerBase  = townPointerBase + 0x5e*0x46 // TTD's pointer to the first order in Schedules
+	boundingBlocks    = 0x1000
+	vehicleSize       = 0x80
+	maxVehicles       = 0x352
 	placeholder4      = 0xe*0x28 + 0x1c*0x100
 	placeholder5      = 6*2*0xc + 2*0x100 + 0x90
 	placeholder6      = 0x20 + 3*0xc
@@ -23,11 +30,12 @@ const (
 		14 +
 		placeholder1 + // costs, cargo
 		6*NumberOfTiles + 0x4000 +
-		placeholder2 + // stations, industry
+		stationSize*maxStations +
+		industrySize*maxIndustries +
 		8*0x3b2 + // companies
-		placeholder3 + // vehicles
+		vehicleSize*maxVehicles +
 		0x20*0x1f4 + // custom strings
-		0x1000*2 + // vehicles in bounding blocks
+		boundingBlocks*2 + // vehicles in bounding blocks
 		placeholder4 + // signs, vehicle types
 		2 + // NextVehicleArray
 		32 + // subsidies
@@ -51,7 +59,11 @@ func titleChecksum(title []byte) uint16 {
 
 func (s *Savegame) checkBytes(bs []byte) {
 	for _, b := range bs {
-		s.Checksum += uint32(b)
-		s.Checksum = (s.Checksum << 3) | (s.Checksum >> 29)
+		s.checkByte(b)
 	}
 }
+
+func (s *Savegame) checkByte(b byte) {
+	s.Checksum += uint32(b)
+	s.Checksum = (s.Checksum << 3) | (s.Checksum >> 29)
+}
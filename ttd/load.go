@@ -1,25 +1,25 @@
 package ttd
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"reflect"
 	"slices"
 )
 
-func (s *Savegame) readUncompressed(f InFile, len int) ([]byte, error) {
+func (s *Savegame) readUncompressed(f io.Reader, len int) ([]byte, error) {
 	b := make([]byte, len)
-	n, err := f.Read(b)
+	_, err := io.ReadFull(f, b)
 	if err != nil {
-		return nil, err
-	}
-	if n != len {
-		return nil, fmt.Errorf("readUncompressed: read %d bytes, expected %d", n, len)
+		return nil, fmt.Errorf("readUncompressed: reading %d bytes: %w", len, err)
 	}
 	s.checkBytes(b)
 	return b, nil
 }
 
-func (s *Savegame) readB(f InFile) (byte, error) {
+func (s *Savegame) readB(f io.Reader) (byte, error) {
 	b, err := s.readUncompressed(f, 1)
 	if err != nil {
 		return 0, err
@@ -30,7 +30,7 @@ func (s *Savegame) readB(f InFile) (byte, error) {
 	return b[0], nil
 }
 
-func (s *Savegame) readWBool(f InFile) (bool, error) {
+func (s *Savegame) readWBool(f io.Reader) (bool, error) {
 	b, err := s.readW(f)
 	if err != nil {
 		return false, err
@@ -41,7 +41,7 @@ func (s *Savegame) readWBool(f InFile) (bool, error) {
 	return true, nil
 }
 
-func (s *Savegame) readW(f InFile) (uint16, error) {
+func (s *Savegame) readW(f io.Reader) (uint16, error) {
 	b, err := s.readUncompressed(f, 2)
 	if err != nil {
 		return 0, err
@@ -52,7 +52,7 @@ func (s *Savegame) readW(f InFile) (uint16, error) {
 	return uint16(b[1])<<8 + uint16(b[0]), nil
 }
 
-func (s *Savegame) readL(f InFile) (uint32, error) {
+func (s *Savegame) readL(f io.Reader) (uint32, error) {
 	b, err := s.readUncompressed(f, 4)
 	if err != nil {
 		return 0, err
@@ -63,7 +63,7 @@ func (s *Savegame) readL(f InFile) (uint32, error) {
 	return uint32(b[3])<<24 + uint32(b[2])<<16 + uint32(b[1])<<8 + uint32(b[0]), nil
 }
 
-func (s *Savegame) readLL(f InFile) (uint64, error) {
+func (s *Savegame) readLL(f io.Reader) (uint64, error) {
 	b, err := s.readUncompressed(f, 8)
 	if err != nil {
 		return 0, err
@@ -74,50 +74,38 @@ func (s *Savegame) readLL(f InFile) (uint64, error) {
 	return uint64(b[7])<<56 + uint64(b[6])<<48 + uint64(b[5])<<40 + uint64(b[4])<<32 + uint64(b[3])<<24 + uint64(b[2])<<16 + uint64(b[1])<<8 + uint64(b[0]), nil
 }
 
-func (s *Savegame) readCompressed(f InFile, l int) ([]byte, error) {
+func (s *Savegame) readCompressed(f *bufio.Reader, l int) ([]byte, error) {
 	out := make([]byte, 0, l)
 	for len(out) < l {
-		cb, err := s.readB(f)
-		c := int8(cb)
+		cb, err := f.ReadByte()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("readCompressed: %w", err)
 		}
+		s.checkByte(cb)
+		c := int8(cb)
 		if c >= 0 {
-			r, err := s.readUncompressed(f, int(cb+1))
+			n := len(out)
+			out = append(out, make([]byte, int(c)+1)...)
+			_, err := io.ReadFull(f, out[n:])
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("readCompressed: %w", err)
 			}
-			out = append(out, r...)
+			s.checkBytes(out[n:])
 		} else {
-			b, err := s.readB(f)
+			b, err := f.ReadByte()
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("readCompressed: %w", err)
+			}
+			s.checkByte(b)
+			for range 1 - int(c) {
+				out = append(out, b)
 			}
-			r := slices.Repeat([]byte{b}, int(-c+1))
-			out = append(out, r...)
 		}
 	}
 	return out, nil
 }
 
-type bytesFile struct {
-	data  []byte
-	index int
-}
-
-func (f *bytesFile) Read(b []byte) (int, error) {
-	l := len(b)
-	if l > len(f.data)-f.index {
-		l = len(f.data) - f.index
-	}
-	for i := range l {
-		b[i] = f.data[i+f.index]
-	}
-	f.index += l
-	return l, nil
-}
-
-func (s *Savegame) readStruct(f InFile, v reflect.Value) error {
+func (s *Savegame) readStruct(f io.Reader, v reflect.Value) error {
 	for i := range v.NumField() {
 		switch v.Field(i).Type().Name() {
 		case "uint16":
@@ -143,7 +131,8 @@ func boolFromByte(b byte, i int) bool {
 	return (b>>i)&1 != 0
 }
 
-func Uncompress(f InFile) (*Savegame, []byte, uint32, error) {
+func Uncompress(r io.Reader) (*Savegame, []byte, uint32, error) {
+	f := bufio.NewReader(r)
 	s := Savegame{
 		Checksum: 0,
 	}
@@ -177,14 +166,151 @@ func Uncompress(f InFile) (*Savegame, []byte, uint32, error) {
 	return &s, uncompressed, calculatedChecksum, nil
 }
 
+// usedSlots returns the slots up to the last used one, or nil if none is
+// used.
+func usedSlots[T any](slots []T, used func(T) bool) []T {
+	n := 0
+	for i, slot := range slots {
+		if used(slot) {
+			n = i + 1
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return slots[:n:n]
+}
+
+func industryFromBytes(d []byte) Industry {
+	w := func(o int) uint16 {
+		return uint16(d[o]) | uint16(d[o+1])<<8
+	}
+	town := uint32(w(2)) | uint32(w(4))<<16
+	i := Industry{
+		X:                       d[0],
+		Y:                       d[1],
+		Width:                   d[6],
+		Height:                  d[7],
+		ProducedCargo:           [2]uint8{d[8], d[9]},
+		ProducedCargoWaiting:    [2]uint16{w(10), w(12)},
+		ProductionRate:          [2]uint8{d[14], d[15]},
+		AcceptedCargo:           [3]uint8{d[16], d[17], d[18]},
+		ProductionLevel:         d[19],
+		ThisMonthProduction:     [2]uint16{w(20), w(22)},
+		ThisMonthTransported:    [2]uint16{w(24), w(26)},
+		LastMonthPctTransported: [2]uint8{d[28], d[29]},
+		LastMonthProduction:     [2]uint16{w(30), w(32)},
+		LastMonthTransported:    [2]uint16{w(34), w(36)},
+		Type:                    d[38],
+		Owner:                   d[39],
+		Colour:                  d[40],
+		LastProductionYear:      d[41],
+		Counter:                 w(42),
+		WasCargoDelivered:       d[44],
+	}
+	if town >= townPointerBase {
+		i.Town = uint8((town - townPointerBase) / 0x5e)
+	}
+	return i
+}
+
+func stationFromBytes(d []byte) Station {
+	w := func(o int) uint16 {
+		return uint16(d[o]) | uint16(d[o+1])<<8
+	}
+	town := uint32(w(2)) | uint32(w(4))<<16
+	st := Station{
+		X:                d[0],
+		Y:                d[1],
+		BusStop:          w(0x06),
+		TruckStop:        w(0x08),
+		Rail:             w(0x0a),
+		Airport:          w(0x0c),
+		Dock:             w(0x0e),
+		Platforms:        d[0x10],
+		NameID:           w(0x14),
+		HadVehicleOfType: w(0x1a),
+		TimeSinceLoad:    d[0x7c],
+		TimeSinceUnload:  d[0x7d],
+		DeleteCounter:    d[0x7e],
+		Owner:            d[0x7f],
+		Facilities:       d[0x80],
+		AirportType:      d[0x81],
+		AirportFlags:     w(0x86),
+	}
+	for i := range st.Cargo {
+		o := 0x1c + i*8
+		st.Cargo[i] = StationCargo{
+			Waiting:         w(o) & 0x0fff,
+			Accepted:        w(o)&0x8000 != 0,
+			DaysSincePickup: d[o+2],
+			Rating:          d[o+3],
+			Source:          d[o+4],
+			Days:            d[o+5],
+			LastSpeed:       d[o+6],
+			LastAge:         d[o+7],
+		}
+	}
+	if town >= townPointerBase {
+		st.Town = uint8((town - townPointerBase) / 0x5e)
+	}
+	return st
+}
+
+func vehicleFromBytes(d []byte) Vehicle {
+	w := func(o int) uint16 {
+		return uint16(d[o]) | uint16(d[o+1])<<8
+	}
+	l := func(o int) uint32 {
+		return uint32(w(o)) | uint32(w(o+2))<<16
+	}
+	v := Vehicle{
+		Type:              d[0x00],
+		Subtype:           d[0x01],
+		CurrentOrder:      w(0x0a),
+		NumberOfOrders:    d[0x0c],
+		CurrentOrderIndex: d[0x0d],
+		Destination:       w(0x0e),
+		X:                 w(0x1a),
+		Y:                 w(0x1c),
+		Z:                 d[0x1e],
+		Direction:         d[0x1f],
+		Owner:             d[0x25],
+		Tile:              w(0x26),
+		Status:            w(0x32),
+		Speed:             w(0x34),
+		CargoType:         d[0x39],
+		CargoCapacity:     w(0x3a),
+		Cargo:             w(0x3c),
+		CargoSource:       d[0x3e],
+		CargoDays:         d[0x3f],
+		Age:               w(0x40),
+		MaxAge:            w(0x42),
+		BuildYear:         d[0x44],
+		UnitNumber:        d[0x45],
+		Engine:            w(0x46),
+		Reliability:       w(0x4e),
+		ReliabilityDecay:  w(0x50),
+		ProfitThisYear:    int32(l(0x52)),
+		ProfitLastYear:    int32(l(0x56)),
+		Next:              w(0x5a),
+		Value:             l(0x5c),
+	}
+	// vehicles without orders have no pointer
+	if l(0x06) != 0 {
+		v.Orders = uint16((l(0x06) - orderPointerBase) / 2)
+	}
+	return v
+}
+
 // doesn't support all text IDs
-func Load(f InFile) (*Savegame, error) {
+func Load(f io.Reader) (*Savegame, error) {
 	s, uncompressed, checksum, err := Uncompress(f)
 	if err != nil {
 		return nil, err
 	}
 	// treat uncompressed data as a fake file, so we can reuse same functions
-	bf := &bytesFile{data: uncompressed}
+	bf := bytes.NewReader(uncompressed)
 
 	s.Tiles = make([]Tile, NumberOfTiles)
 
@@ -204,17 +330,19 @@ func Load(f InFile) (*Savegame, error) {
 		if err != nil {
 			return nil, err
 		}
-		if e.ID != 0xFFFF {
-			s.TextEffects = append(s.TextEffects, e)
-		}
+		s.TextEffects = append(s.TextEffects, e)
 	}
+	// text effects, animations and depots keep their empty slots too, so that
+	// they are saved at the same offsets, and depot orders still find them
+	s.TextEffects = usedSlots(s.TextEffects, func(e TextEffect) bool { return e.ID != 0xFFFF })
 
 	s.Seed, err = s.readLL(bf)
 	if err != nil {
 		return nil, err
 	}
 
-	var townNames []uint16
+	// towns, industries and companies keep their empty slots like stations
+	// and vehicles, because they are referred to by index as well
 	for range 0x46 {
 		t := Town{}
 		t.X, err = s.readB(bf)
@@ -229,27 +357,29 @@ func Load(f InFile) (*Savegame, error) {
 		if err != nil {
 			return nil, err
 		}
-		name, err := s.readW(bf)
+		t.NameID, err = s.readW(bf)
 		if err != nil {
 			return nil, err
 		}
-		townNames = append(townNames, name)
-		_, err = s.readUncompressed(bf, townPlaceholder)
+		tail, err := s.readUncompressed(bf, townPlaceholder)
 		if err != nil {
 			return nil, err
 		}
-		if t.X != 0 || t.Y != 0 {
-			s.Towns = append(s.Towns, t)
-		}
+		s.Unparsed.TownTails = append(s.Unparsed.TownTails, tail...)
+		s.Towns = append(s.Towns, t)
 	}
+	s.Towns = usedSlots(s.Towns, func(t Town) bool { return t.X != 0 || t.Y != 0 })
 
-	for range 0x1388 {
-		c, err := s.readW(bf)
+	// zeros end the orders of a vehicle, so only the ones at the end are left
+	// out
+	schedules := make([]uint16, 0x1388)
+	for i := range schedules {
+		schedules[i], err = s.readW(bf)
 		if err != nil {
 			return nil, err
 		}
-		if c != 0 {
-			s.Schedules = append(s.Schedules, c)
+		if schedules[i] != 0 {
+			s.Schedules = schedules[:i+1]
 		}
 	}
 
@@ -258,12 +388,11 @@ func Load(f InFile) (*Savegame, error) {
 		if err != nil {
 			return nil, err
 		}
-		if c != 0 {
-			s.Animations = append(s.Animations, c)
-		}
+		s.Animations = append(s.Animations, c)
 	}
+	s.Animations = usedSlots(s.Animations, func(c uint16) bool { return c != 0 })
 
-	_, err = s.readL(bf)
+	s.Unparsed.SchedulesEnd, err = s.readUncompressed(bf, 4)
 	if err != nil {
 		return nil, err
 	}
@@ -275,10 +404,9 @@ func Load(f InFile) (*Savegame, error) {
 		if err != nil {
 			return nil, err
 		}
-		if d.XY != 0 {
-			s.Depots = append(s.Depots, d)
-		}
+		s.Depots = append(s.Depots, d)
 	}
+	s.Depots = usedSlots(s.Depots, func(d Depot) bool { return d.XY != 0 })
 
 	s.NextProcessedTown, err = s.readL(bf)
 	if err != nil {
@@ -305,8 +433,7 @@ func Load(f InFile) (*Savegame, error) {
 		return nil, err
 	}
 
-	// placeholder for costs and cargo
-	_, err = s.readUncompressed(bf, placeholder1)
+	s.Unparsed.CostsAndCargo, err = s.readUncompressed(bf, placeholder1)
 	if err != nil {
 		return nil, err
 	}
@@ -319,26 +446,46 @@ func Load(f InFile) (*Savegame, error) {
 	if err != nil {
 		return nil, err
 	}
-	_, err = s.readUncompressed(bf, 2*NumberOfTiles) // L3
+	L3, err := s.readUncompressed(bf, 2*NumberOfTiles)
 	if err != nil {
 		return nil, err
 	}
-	_, err = s.readUncompressed(bf, 0x4000) // desert
+	desert, err := s.readUncompressed(bf, NumberOfTiles/4)
 	if err != nil {
 		return nil, err
 	}
+	if slices.ContainsFunc(desert, func(b byte) bool { return b != 0 }) {
+		s.TropicZones = make([]uint8, NumberOfTiles)
+		for i := range s.TropicZones {
+			s.TropicZones[i] = desert[i/4] >> (i % 4 * 2) & 3
+		}
+	}
 
-	// placeholder for stations, industry
-	_, err = s.readUncompressed(bf, placeholder2)
-	if err != nil {
-		return nil, err
+	// stations keep their empty slots, because tiles and vehicles refer to
+	// them by index
+	for range maxStations {
+		data, err := s.readUncompressed(bf, stationSize)
+		if err != nil {
+			return nil, err
+		}
+		s.Unparsed.Stations = append(s.Unparsed.Stations, data...)
+		s.Stations = append(s.Stations, stationFromBytes(data))
 	}
+	s.Stations = usedSlots(s.Stations, func(st Station) bool { return st.X != 0 || st.Y != 0 })
 
-	companyNames := make([]uint16, 8)
-	managerNames := make([]uint16, 8)
-	for i := range 8 {
+	for range maxIndustries {
+		data, err := s.readUncompressed(bf, industrySize)
+		if err != nil {
+			return nil, err
+		}
+		s.Unparsed.Industries = append(s.Unparsed.Industries, data...)
+		s.Industries = append(s.Industries, industryFromBytes(data))
+	}
+	s.Industries = usedSlots(s.Industries, func(i Industry) bool { return i.X != 0 || i.Y != 0 })
+
+	for range 8 {
 		c := Company{}
-		companyNames[i], err = s.readW(bf)
+		c.NameID, err = s.readW(bf)
 		if err != nil {
 			return nil, err
 		}
@@ -350,7 +497,7 @@ func Load(f InFile) (*Savegame, error) {
 		if err != nil {
 			return nil, err
 		}
-		managerNames[i], err = s.readW(bf)
+		c.ManagerNameID, err = s.readW(bf)
 		if err != nil {
 			return nil, err
 		}
@@ -358,51 +505,56 @@ func Load(f InFile) (*Savegame, error) {
 		if err != nil {
 			return nil, err
 		}
-		_, err = s.readUncompressed(bf, 0x3b2-16)
+		body, err := s.readUncompressed(bf, 0x3b2-16)
 		if err != nil {
 			return nil, err
 		}
-		if companyNames[i] != 0 {
-			s.Companies = append(s.Companies, c)
+		s.Unparsed.CompanyBodies = append(s.Unparsed.CompanyBodies, body...)
+		s.Companies = append(s.Companies, c)
+	}
+	s.Companies = usedSlots(s.Companies, func(c Company) bool { return c.NameID != 0 })
+
+	// vehicles keep their empty slots too, because the wagons of a train
+	// refer to each other by index
+	for range maxVehicles {
+		data, err := s.readUncompressed(bf, vehicleSize)
+		if err != nil {
+			return nil, err
 		}
+		s.Unparsed.Vehicles = append(s.Unparsed.Vehicles, data...)
+		s.Vehicles = append(s.Vehicles, vehicleFromBytes(data))
 	}
+	s.Vehicles = usedSlots(s.Vehicles, func(v Vehicle) bool { return v.Type != 0 })
 
-	// placeholder vehicles
-	_, err = s.readUncompressed(bf, placeholder3)
+	s.Unparsed.CustomStrings, err = s.readUncompressed(bf, 0x20*0x1f4)
 	if err != nil {
 		return nil, err
 	}
-
-	for i := range 0x1f4 {
-		c, err := s.readUncompressed(bf, 0x20)
-		if err != nil {
-			return nil, err
-		}
-		str := string(c)
-		idx := uint16(i + firstCustomTextID)
-		for j := range s.Towns {
-			if townNames[j] == idx {
-				s.Towns[i].Name = str
-			}
-		}
-		for j := range s.Companies {
-			if companyNames[j] == idx {
-				s.Companies[j].Name = str
-			}
-			if managerNames[j] == idx {
-				s.Companies[j].ManagerName = str
-			}
+	customString := func(id uint16) string {
+		if id < firstCustomTextID || id >= firstCustomTextID+0x1f4 {
+			return ""
 		}
+		i := int(id-firstCustomTextID) * 0x20
+		return string(s.Unparsed.CustomStrings[i : i+0x20])
+	}
+	for i := range s.Towns {
+		s.Towns[i].Name = customString(s.Towns[i].NameID)
+	}
+	for i := range s.Stations {
+		s.Stations[i].Name = customString(s.Stations[i].NameID)
+	}
+	for i := range s.Companies {
+		s.Companies[i].Name = customString(s.Companies[i].NameID)
+		s.Companies[i].ManagerName = customString(s.Companies[i].ManagerNameID)
 	}
 
-	// vehicles in bounding blocks
-	_, err = s.readUncompressed(bf, 0x1000*2)
+	// vehicles in bounding blocks, which are recalculated on save
+	_, err = s.readUncompressed(bf, boundingBlocks*2)
 	if err != nil {
 		return nil, err
 	}
 
-	// placeholder for signs, vehicle types
-	_, err = s.readUncompressed(bf, placeholder4)
+	s.Unparsed.SignsAndVehicleTypes, err = s.readUncompressed(bf, placeholder4)
 	if err != nil {
 		return nil, err
 	}
@@ -412,8 +564,7 @@ func Load(f InFile) (*Savegame, error) {
 		return nil, err
 	}
 
-	// placeholder for subsidies
-	_, err = s.readUncompressed(bf, 32)
+	s.Unparsed.Subsidies, err = s.readUncompressed(bf, 32)
 	if err != nil {
 		return nil, err
 	}
@@ -451,8 +602,7 @@ func Load(f InFile) (*Savegame, error) {
 		return nil, err
 	}
 
-	// placeholder for pointers to text IDs
-	_, err = s.readUncompressed(bf, placeholder5)
+	s.Unparsed.TextPointers, err = s.readUncompressed(bf, placeholder5)
 	if err != nil {
 		return nil, err
 	}
@@ -489,7 +639,7 @@ func Load(f InFile) (*Savegame, error) {
 	if err != nil {
 		return nil, err
 	}
-	_, err = s.readUncompressed(bf, 8)
+	s.Unparsed.AfterDate, err = s.readUncompressed(bf, 8)
 	if err != nil {
 		return nil, err
 	}
@@ -612,7 +762,7 @@ func Load(f InFile) (*Savegame, error) {
 	if err != nil {
 		return nil, err
 	}
-	_, err = s.readUncompressed(bf, placeholder6)
+	s.Unparsed.IndustryAndCargoTypes, err = s.readUncompressed(bf, placeholder6)
 	if err != nil {
 		return nil, err
 	}
@@ -630,22 +780,79 @@ func Load(f InFile) (*Savegame, error) {
 		s.Tiles[i].Height = L4[i] & 0x0f
 		if s.Tiles[i].Class == 0 { // normal,
 			s.Tiles[i].Owner = L1[i]
-			s.Tiles[i].Type = L5[i] & 0x0f
+			s.Tiles[i].Type = L5[i] & 0x1f
+			s.Tiles[i].Industry = L2[i]
+			s.Tiles[i].Field = L3[2*i] & 0x0f
+			s.Tiles[i].HedgeSE = L3[2*i+1] >> 2 & 7
+			s.Tiles[i].HedgeSW = L3[2*i+1] >> 5
+		} else if s.Tiles[i].Class == 1 { // rail
+			s.Tiles[i].Owner = L1[i]
+			s.Tiles[i].Ground = L2[i] & 0x0f
+			s.Tiles[i].Type = L5[i]
+		} else if s.Tiles[i].Class == 2 && L5[i]&0xf0 == 0x10 { // level crossing
+			s.Tiles[i].Crossing = true
+			s.Tiles[i].Axis = L5[i] >> 3 & 1
+			s.Tiles[i].RailOwner = L1[i]
+			s.Tiles[i].Owner = L3[2*i]
+		} else if s.Tiles[i].Class == 2 && L5[i]&0xf0 == 0x20 { // road depot
+			s.Tiles[i].Depot = true
+			s.Tiles[i].Direction = L5[i] & 3
+			s.Tiles[i].Owner = L1[i]
 		} else if s.Tiles[i].Class == 2 { // road
 			s.Tiles[i].Owner = L1[i]
 			s.Tiles[i].Type = L5[i] & 0x0f
 		} else if s.Tiles[i].Class == 3 { // town building
 			s.Tiles[i].Type = L2[i]
+		} else if s.Tiles[i].Class == 4 { // trees
+			s.Tiles[i].Owner = L1[i]
+			s.Tiles[i].Ground = L2[i] >> 6
+			s.Tiles[i].Density = L2[i] >> 4 & 3
+			s.Tiles[i].Type = L3[2*i]
+			s.Tiles[i].Trees = L5[i]>>6 + 1
+			s.Tiles[i].Growth = L5[i] & 7
+		} else if s.Tiles[i].Class == 5 { // station
+			s.Tiles[i].Owner = L1[i]
+			s.Tiles[i].Station = L2[i]
+			s.Tiles[i].Type = L5[i]
+		} else if s.Tiles[i].Class == 6 && L5[i]&0xfc == 0x80 { // ship depot
+			s.Tiles[i].Depot = true
+			s.Tiles[i].Axis = L5[i] >> 1 & 1
+			s.Tiles[i].DepotPart = L5[i] & 1
+			s.Tiles[i].Owner = L1[i]
 		} else if s.Tiles[i].Class == 6 { // water
 			s.Tiles[i].Owner = L1[i]
 			s.Tiles[i].Type = L5[i]
-		} else {
-			return nil, fmt.Errorf("Unsupported tile class %x\n", s.Tiles[i].Class)
+		} else if s.Tiles[i].Class == 8 { // industry
+			s.Tiles[i].Stage = L1[i] & 3
+			s.Tiles[i].Industry = L2[i]
+			s.Tiles[i].Type = L5[i]
+		} else if s.Tiles[i].Class == 9 { // tunnel or bridge
+			s.Tiles[i].Owner = L1[i]
+			if L5[i]&0x80 == 0 {
+				s.Tiles[i].Tunnel = true
+				s.Tiles[i].Transport = L5[i] >> 2 & 1
+				s.Tiles[i].Direction = L5[i] & 3
+				continue
+			}
+			s.Tiles[i].Transport = L5[i] >> 1 & 1
+			s.Tiles[i].Axis = L5[i] & 1
+			s.Tiles[i].BridgeType = L2[i] >> 4
+			if L5[i]&0x40 == 0 {
+				s.Tiles[i].BridgeEnd = 1 + L5[i]>>5&1
+			} else {
+				s.Tiles[i].BridgePiece = L2[i] & 0x0f
+				s.Tiles[i].UnderBridge = L5[i] >> 3 & 1
+				if L5[i]&0x20 != 0 { // rail or road
+					s.Tiles[i].UnderBridge += UnderBridgeRail
+				}
+			}
 		}
+		// other classes are only kept in the unparsed layers
 	}
+	s.Unparsed.L1, s.Unparsed.L2, s.Unparsed.L3, s.Unparsed.L4, s.Unparsed.L5 = L1, L2, L3, L4, L5
 
-	if bf.index != len(bf.data) {
-		return nil, fmt.Errorf("not all uncompressed bytes used: %d used, %d total", bf.index, len(bf.data))
+	if bf.Len() != 0 {
+		return nil, fmt.Errorf("not all uncompressed bytes used: %d used, %d total", len(uncompressed)-bf.Len(), len(uncompressed))
 	}
 
 	s.Checksum = checksum
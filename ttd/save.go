@@ -2,6 +2,7 @@ package ttd
 
 import (
 	"fmt"
+	"io"
 	"reflect"
 	"slices"
 )
@@ -56,32 +57,107 @@ func structToBytes(v reflect.Value) ([]byte, error) {
 	return b, nil
 }
 
-func (s *Savegame) writeUncompressed(f OutFile, b []byte) error {
+func (s *Savegame) writeUncompressed(f io.Writer, b []byte) error {
 	n, err := f.Write(b)
 	if err != nil {
 		return err
 	}
 	if n != len(b) {
-		fmt.Errorf("writeUncompressed wrote %d bytes, expected %d", n, len(b))
+		return fmt.Errorf("writeUncompressed wrote %d bytes, expected %d", n, len(b))
 	}
 	s.checkBytes(b)
 	return nil
 }
 
-func (s *Savegame) writeCompressed(f OutFile, data []byte) error {
-	const maxc = 127 + 1
-	for i := 0; i < len(data); i += maxc {
-		c := len(data) - i
-		if c > maxc {
-			c = maxc
+func (s *Savegame) writeCompressed(f io.Writer, data []byte) error {
+	return s.writeUncompressed(f, compress(data))
+}
+
+const (
+	maxLiteral = 128 // bytes after a control byte from 0 to 127
+	maxRepeat  = 129 // repeats of the byte after a control byte from -1 to -128
+)
+
+// compress encodes data in as few bytes as possible with the run-length
+// encoding of savegames: chunks of a control byte c followed by c+1 literal
+// bytes if c is positive, or by a byte that is repeated 1-c times if c is
+// negative.
+//
+// cost[i] is the size of the shortest encoding of data[i:], which is found
+// from the end by trying every literal and every repeat chunk at i. The best
+// chunks are found with a sliding window minimum, so it takes linear time.
+func compress(data []byte) []byte {
+	n := len(data)
+	cost := make([]int, n+1)
+	end := make([]int, n+1) // end of the first chunk of data[i:]
+	repeat := make([]bool, n+1)
+
+	// positions j after a literal chunk at i, by increasing j + cost[j]
+	var literals window
+	// positions j after a repeat chunk at i, by increasing cost[j]
+	var repeats window
+	runEnd := n
+	for i := n - 1; i >= 0; i-- {
+		literals.push(i+1, i+1+cost[i+1])
+		literals.expire(i + maxLiteral)
+		j := literals.min()
+		cost[i], end[i] = 1+j-i+cost[j], j
+
+		if i+1 < n && data[i+1] != data[i] {
+			runEnd = i + 1
+			repeats.reset()
 		}
-		b := append([]byte{byte(c - 1)}, data[i:i+c]...)
-		err := s.writeUncompressed(f, b)
-		if err != nil {
-			return err
+		if i+2 <= runEnd {
+			repeats.push(i+2, cost[i+2])
+			repeats.expire(i + maxRepeat)
+			if j := repeats.min(); 2+cost[j] < cost[i] {
+				cost[i], end[i], repeat[i] = 2+cost[j], j, true
+			}
 		}
 	}
-	return nil
+
+	out := make([]byte, 0, cost[0])
+	for i := 0; i < n; i = end[i] {
+		if repeat[i] {
+			out = append(out, byte(1-(end[i]-i)), data[i])
+		} else {
+			out = append(out, byte(end[i]-i-1))
+			out = append(out, data[i:end[i]]...)
+		}
+	}
+	return out
+}
+
+// window is a sliding window minimum over positions that are added in
+// decreasing order.
+type window struct {
+	positions []int
+	values    []int
+	start     int
+}
+
+func (w *window) push(position, value int) {
+	for len(w.positions) > w.start && w.values[len(w.values)-1] >= value {
+		w.positions = w.positions[:len(w.positions)-1]
+		w.values = w.values[:len(w.values)-1]
+	}
+	w.positions = append(w.positions, position)
+	w.values = append(w.values, value)
+}
+
+// expire removes the positions after last.
+func (w *window) expire(last int) {
+	for w.positions[w.start] > last {
+		w.start++
+	}
+}
+
+func (w *window) min() int {
+	return w.positions[w.start]
+}
+
+func (w *window) reset() {
+	w.positions, w.values, w.start = w.positions[:0], w.values[:0], 0
 }
 
 func bools(in []bool) []byte {
@@ -106,6 +182,30 @@ func (s *Savegame) Validate() error {
 	if len(s.Towns) > 70 {
 		return fmt.Errorf("Too many towns (%d)", len(s.Towns))
 	}
+	if len(s.Stations) > maxStations {
+		return fmt.Errorf("Too many stations (%d)", len(s.Stations))
+	}
+	for _, st := range s.Stations {
+		if (st.X != 0 || st.Y != 0) && int(st.Town) >= len(s.Towns) {
+			return fmt.Errorf("Station of town %d, but there are only %d towns", st.Town, len(s.Towns))
+		}
+	}
+	if len(s.Vehicles) > maxVehicles {
+		return fmt.Errorf("Too many vehicles (%d)", len(s.Vehicles))
+	}
+	for i, v := range s.Vehicles {
+		if v.Type != 0 && v.Next != NoVehicle && (int(v.Next) >= len(s.Vehicles) || s.Vehicles[v.Next].Type == 0) {
+			return fmt.Errorf("Vehicle %d is followed by vehicle %d, which doesn't exist", i, v.Next)
+		}
+	}
+	if len(s.Industries) > maxIndustries {
+		return fmt.Errorf("Too many industries (%d)", len(s.Industries))
+	}
+	for _, i := range s.Industries {
+		if (i.X != 0 || i.Y != 0) && int(i.Town) >= len(s.Towns) {
+			return fmt.Errorf("Industry at %d, %d belongs to town %d, but there are %d towns", i.X, i.Y, i.Town, len(s.Towns))
+		}
+	}
 	if len(s.Depots) > 255 {
 		return fmt.Errorf("Too many depots (%d)", len(s.Depots))
 	}
@@ -118,6 +218,41 @@ func (s *Savegame) Validate() error {
 	if len(s.Tiles) != NumberOfTiles {
 		return fmt.Errorf("Need exactly 0x10000 tiles (256x256), got %d\n", len(s.Tiles))
 	}
+	u := &s.Unparsed
+	for _, r := range []struct {
+		name   string
+		region []byte
+		size   int
+	}{
+		{"TownTails", u.TownTails, 0x46 * townPlaceholder},
+		{"SchedulesEnd", u.SchedulesEnd, 4},
+		{"CostsAndCargo", u.CostsAndCargo, placeholder1},
+		{"Stations", u.Stations, stationSize * maxStations},
+		{"Industries", u.Industries, industrySize * maxIndustries},
+		{"CompanyBodies", u.CompanyBodies, 8 * (0x3b2 - 16)},
+		{"Vehicles", u.Vehicles, vehicleSize * maxVehicles},
+		{"CustomStrings", u.CustomStrings, 0x20 * 0x1f4},
+		{"SignsAndVehicleTypes", u.SignsAndVehicleTypes, placeholder4},
+		{"Subsidies", u.Subsidies, 32},
+		{"TextPointers", u.TextPointers, placeholder5},
+		{"AfterDate", u.AfterDate, 8},
+		{"IndustryAndCargoTypes", u.IndustryAndCargoTypes, placeholder6},
+		{"L1", u.L1, NumberOfTiles},
+		{"L2", u.L2, NumberOfTiles},
+		{"L3", u.L3, 2 * NumberOfTiles},
+		{"L4", u.L4, NumberOfTiles},
+		{"L5", u.L5, NumberOfTiles},
+	} {
+		if r.region != nil && len(r.region) != r.size {
+			return fmt.Errorf("Unparsed %s has %d bytes, expected %d", r.name, len(r.region), r.size)
+		}
+	}
+	if u.L4 != nil && (u.L1 == nil || u.L2 == nil || u.L3 == nil || u.L5 == nil) {
+		return fmt.Errorf("Unparsed L4 needs the other tile layers")
+	}
+	if s.TropicZones != nil && len(s.TropicZones) != NumberOfTiles {
+		return fmt.Errorf("Need a tropic zone for each tile, got %d\n", len(s.TropicZones))
+	}
 	return nil
 }
 
@@ -128,31 +263,317 @@ func get[T any](array []T, i int, def T) T {
 	return array[i]
 }
 
-func (s *Savegame) Save(f OutFile) error {
+// industryBytes encodes an industry, keeping the bytes of its slot that
+// aren't decoded.
+func industryBytes(i Industry, slot []byte) []byte {
+	town := uint32(0)
+	if i.X != 0 || i.Y != 0 {
+		town = townPointerBase + uint32(i.Town)*0x5e
+	}
+	return slices.Concat(
+		w(uint16(i.Y)<<8|uint16(i.X)),
+		l(town),
+		b(i.Width), b(i.Height),
+		i.ProducedCargo[:],
+		w(i.ProducedCargoWaiting[0]), w(i.ProducedCargoWaiting[1]),
+		i.ProductionRate[:],
+		i.AcceptedCargo[:],
+		b(i.ProductionLevel),
+		w(i.ThisMonthProduction[0]), w(i.ThisMonthProduction[1]),
+		w(i.ThisMonthTransported[0]), w(i.ThisMonthTransported[1]),
+		i.LastMonthPctTransported[:],
+		w(i.LastMonthProduction[0]), w(i.LastMonthProduction[1]),
+		w(i.LastMonthTransported[0]), w(i.LastMonthTransported[1]),
+		b(i.Type),
+		b(i.Owner),
+		b(i.Colour),
+		b(i.LastProductionYear),
+		w(i.Counter),
+		b(i.WasCargoDelivered),
+		slot[0x2d:])
+}
+
+// stationBytes encodes a station over the bytes of its slot that aren't
+// decoded.
+func stationBytes(st Station, name uint16, slot []byte) []byte {
+	town := uint32(0)
+	if st.X != 0 || st.Y != 0 {
+		town = townPointerBase + uint32(st.Town)*0x5e
+	}
+	d := slices.Clone(slot)
+	copy(d[0x00:], w(uint16(st.Y)<<8|uint16(st.X)))
+	copy(d[0x02:], l(town))
+	copy(d[0x06:], w(st.BusStop))
+	copy(d[0x08:], w(st.TruckStop))
+	copy(d[0x0a:], w(st.Rail))
+	copy(d[0x0c:], w(st.Airport))
+	copy(d[0x0e:], w(st.Dock))
+	d[0x10] = st.Platforms
+	copy(d[0x14:], w(name))
+	copy(d[0x1a:], w(st.HadVehicleOfType))
+	for i, c := range st.Cargo {
+		o := 0x1c + i*8
+		waiting := c.Waiting & 0x0fff
+		if c.Accepted {
+			waiting |= 0x8000
+		}
+		copy(d[o:], w(waiting))
+		d[o+2], d[o+3], d[o+4], d[o+5], d[o+6], d[o+7] = c.DaysSincePickup, c.Rating, c.Source, c.Days, c.LastSpeed, c.LastAge
+	}
+	d[0x7c], d[0x7d], d[0x7e], d[0x7f] = st.TimeSinceLoad, st.TimeSinceUnload, st.DeleteCounter, st.Owner
+	d[0x80], d[0x81] = st.Facilities, st.AirportType
+	copy(d[0x86:], w(st.AirportFlags))
+	return d
+}
+
+// vehicleBytes encodes a vehicle over the bytes of its slot that aren't
+// decoded. The sprite box moves with the vehicle, so that it stays in the
+// right bounding block.
+func vehicleBytes(v Vehicle, slot []byte) []byte {
+	d := slices.Clone(slot)
+	old := vehicleFromBytes(slot)
+	d[0x00], d[0x01] = v.Type, v.Subtype
+	if v.Orders != old.Orders || v.NumberOfOrders > 0 && slices.Equal(d[0x06:0x0a], l(0)) {
+		copy(d[0x06:], l(orderPointerBase+2*uint32(v.Orders)))
+	}
+	copy(d[0x0a:], w(v.CurrentOrder))
+	d[0x0c], d[0x0d] = v.NumberOfOrders, v.CurrentOrderIndex
+	copy(d[0x0e:], w(v.Destination))
+	copy(d[0x1a:], w(v.X))
+	copy(d[0x1c:], w(v.Y))
+	d[0x1e], d[0x1f] = v.Z, v.Direction
+	d[0x25] = v.Owner
+	copy(d[0x26:], w(v.Tile))
+	if coord(d, 0x2a) != invalidCoord {
+		oldX, oldY := screenXY(old)
+		newX, newY := screenXY(v)
+		for i, delta := range []int{newX - oldX, newY - oldY, newX - oldX, newY - oldY} {
+			o := 0x2a + 2*i
+			copy(d[o:], w(uint16(coord(d, o)+delta)))
+		}
+	}
+	copy(d[0x32:], w(v.Status))
+	copy(d[0x34:], w(v.Speed))
+	d[0x39] = v.CargoType
+	copy(d[0x3a:], w(v.CargoCapacity))
+	copy(d[0x3c:], w(v.Cargo))
+	d[0x3e], d[0x3f] = v.CargoSource, v.CargoDays
+	copy(d[0x40:], w(v.Age))
+	copy(d[0x42:], w(v.MaxAge))
+	d[0x44], d[0x45] = v.BuildYear, v.UnitNumber
+	copy(d[0x46:], w(v.Engine))
+	copy(d[0x4e:], w(v.Reliability))
+	copy(d[0x50:], w(v.ReliabilityDecay))
+	copy(d[0x52:], l(uint32(v.ProfitThisYear)))
+	copy(d[0x56:], l(uint32(v.ProfitLastYear)))
+	copy(d[0x5a:], w(v.Next))
+	copy(d[0x5c:], l(v.Value))
+	return d
+}
+
+// invalidCoord is the left of the sprite box of a vehicle that isn't drawn.
+const invalidCoord = -0x8000
+
+// coord returns a coordinate of the sprite box of a vehicle.
+func coord(d []byte, o int) int {
+	return int(int16(uint16(d[o]) | uint16(d[o+1])<<8))
+}
+
+// screenXY returns where a vehicle is on the screen, in pixels.
+func screenXY(v Vehicle) (int, int) {
+	x, y, z := int(v.X), int(v.Y), int(v.Z)
+	return (y - x) * 2, x + y - z
+}
+
+// hashVehicles links the vehicles into the bounding blocks of the screen that
+// the top left corner of their sprite is in, and returns the first vehicle of
+// each block.
+func hashVehicles(vehicles []byte) []byte {
+	blocks := slices.Repeat(w(NoVehicle), boundingBlocks)
+	for i := maxVehicles - 1; i >= 0; i-- {
+		d := vehicles[i*vehicleSize : (i+1)*vehicleSize]
+		if d[0] == 0 {
+			continue
+		}
+		left, top := coord(d, 0x2a), coord(d, 0x2c)
+		if left == invalidCoord {
+			copy(d[0x02:], w(NoVehicle))
+			continue
+		}
+		b := 2 * (top>>6&0x3f<<6 | left>>7&0x3f)
+		copy(d[0x02:], blocks[b:b+2])
+		copy(blocks[b:], w(uint16(i)))
+	}
+	return blocks
+}
+
+// orDefault returns an unparsed region, or the default if it wasn't loaded.
+func orDefault(region, def []byte) []byte {
+	if region == nil {
+		return def
+	}
+	return region
+}
+
+// unparsed returns the part of an unparsed region for slot i, or zeros if it
+// wasn't loaded.
+func unparsed(region []byte, i, size int) []byte {
+	if region == nil {
+		return make([]byte, size)
+	}
+	return region[i*size : (i+1)*size]
+}
+
+// customStrings are the custom strings of a savegame that is being saved.
+// Names keep the custom string they were loaded with, and new names get the
+// first one that is empty and isn't kept by another name.
+type customStrings struct {
+	data []byte // 0x20 bytes per string
+	used []bool
+}
+
+func isCustomString(id uint16) bool {
+	return id >= firstCustomTextID && id < firstCustomTextID+0x1f4
+}
+
+func newCustomStrings(s *Savegame) *customStrings {
+	c := &customStrings{
+		data: slices.Clone(orDefault(s.Unparsed.CustomStrings, make([]byte, 0x20*0x1f4))),
+		used: make([]bool, 0x1f4),
+	}
+	for i := range c.used {
+		c.used[i] = c.data[i*0x20] != 0
+	}
+	keep := func(id uint16) {
+		if isCustomString(id) {
+			c.used[id-firstCustomTextID] = true
+		}
+	}
+	for _, t := range s.Towns {
+		keep(t.NameID)
+	}
+	for _, st := range s.Stations {
+		keep(st.NameID)
+	}
+	for _, co := range s.Companies {
+		keep(co.NameID)
+		keep(co.ManagerNameID)
+	}
+	return c
+}
+
+// add returns the text ID of a name, and sets its custom string. A new
+// custom string is used if the ID is 0.
+func (c *customStrings) add(id uint16, name string) (uint16, error) {
+	if id == 0 {
+		i := slices.Index(c.used, false)
+		if i < 0 {
+			return 0, fmt.Errorf("Too many custom strings, no room for %q", name)
+		}
+		c.used[i] = true
+		id = uint16(i) + firstCustomTextID
+	}
+	if !isCustomString(id) {
+		return id, nil
+	}
+	if len(name) > 0x20 {
+		return 0, fmt.Errorf("Custom string %q exceeds maximum length %d\n", name, 0x20)
+	}
+	copy(c.data[int(id-firstCustomTextID)*0x20:], pad([]byte(name), 0x20))
+	return id, nil
+}
+
+func (s *Savegame) Save(f io.Writer) error {
 	if err := s.Validate(); err != nil {
 		return err
 	}
 
 	L1 := make([]byte, NumberOfTiles)
 	L2 := slices.Repeat([]byte{0}, NumberOfTiles)
-	L3 := slices.Repeat([]byte{0, 0}, NumberOfTiles) // no hedge
-	desert := slices.Repeat([]byte{0}, 0x4000)       // desert: normal
+	L3 := slices.Repeat([]byte{0, 0}, NumberOfTiles)
+	desert := slices.Repeat([]byte{0}, NumberOfTiles/4) // 2 bits per tile
+	for i, zone := range s.TropicZones {
+		desert[i/4] |= (zone & 3) << (i % 4 * 2)
+	}
 	L4 := make([]byte, NumberOfTiles)
 	L5 := make([]byte, NumberOfTiles)
+	u := &s.Unparsed
+	// the bits of the kept layers that aren't decoded into the tile, like the
+	// counters of growing land and trees, are kept as they were
+	merge := func(layer []byte, i int, v, mask byte) {
+		layer[i] = layer[i]&^mask | v&mask
+	}
 	for i, tile := range s.Tiles {
 		L4[i] = (tile.Height & 0x0f) | (tile.Class << 4)
+		kept := u.L4 != nil && u.L4[i]>>4 == tile.Class
+		if kept {
+			L1[i], L2[i], L3[2*i], L3[2*i+1], L5[i] = u.L1[i], u.L2[i], u.L3[2*i], u.L3[2*i+1], u.L5[i]
+		}
 		if tile.Class == 0 { // normal
 			L1[i] = tile.Owner
-			L5[i] = tile.Type & 0x0f
+			L2[i] = tile.Industry
+			merge(L3, 2*i, tile.Field, 0x0f)
+			merge(L3, 2*i+1, (tile.HedgeSE&7)<<2|(tile.HedgeSW&7)<<5, 0xfc)
+			merge(L5, i, tile.Type, 0x1f)
+		} else if tile.Class == 1 { // rail
+			L1[i] = tile.Owner
+			merge(L2, i, tile.Ground, 0x0f)
+			L5[i] = tile.Type
+		} else if tile.Class == 2 && tile.Crossing { // level crossing
+			L1[i] = tile.RailOwner
+			L3[2*i] = tile.Owner
+			L5[i] = 0x10 | (tile.Axis&1)<<3
+		} else if tile.Class == 2 && tile.Depot { // road depot
+			L1[i] = tile.Owner
+			L5[i] = 0x20 | tile.Direction&3
 		} else if tile.Class == 2 { // road
 			L1[i] = tile.Owner
 			L5[i] = tile.Type & 0x0f
 		} else if tile.Class == 3 { // building
 			L2[i] = tile.Type
+		} else if tile.Class == 4 { // trees
+			L1[i] = tile.Owner
+			merge(L2, i, (tile.Ground&3)<<6|(tile.Density&3)<<4, 0xf0)
+			L3[2*i] = tile.Type
+			merge(L5, i, ((tile.Trees-1)&3)<<6|tile.Growth&7, 0xc7)
+		} else if tile.Class == 5 { // station
+			L1[i] = tile.Owner
+			L2[i] = tile.Station
+			L5[i] = tile.Type
+		} else if tile.Class == 6 && tile.Depot { // ship depot
+			L1[i] = tile.Owner
+			L5[i] = 0x80 | (tile.Axis&1)<<1 | tile.DepotPart&1
 		} else if tile.Class == 6 { // water
-			L1[i] = 0x11 // owner
+			L1[i] = tile.Owner
 			L5[i] = tile.Type
-		} else {
+		} else if tile.Class == 8 { // industry
+			stage := tile.Stage & 3
+			if tile.Stage == 3 {
+				stage |= 0x80 // completed
+			}
+			merge(L1, i, stage, 0x83)
+			L2[i] = tile.Industry
+			L5[i] = tile.Type
+		} else if tile.Class == 9 && tile.Tunnel {
+			L1[i] = tile.Owner
+			L5[i] = (tile.Transport&1)<<2 | tile.Direction&3
+		} else if tile.Class == 9 && tile.BridgeEnd != 0 { // bridge head
+			L1[i] = tile.Owner
+			L2[i] = tile.BridgeType << 4
+			L5[i] = 0x80 | (tile.Transport&1)<<1 | tile.Axis&1
+			if tile.BridgeEnd == 2 {
+				L5[i] |= 0x20
+			}
+		} else if tile.Class == 9 { // bridge middle part
+			L1[i] = tile.Owner
+			L2[i] = tile.BridgeType<<4 | tile.BridgePiece&0x0f
+			L5[i] = 0xc0 | (tile.Transport&1)<<1 | tile.Axis&1
+			if tile.UnderBridge >= UnderBridgeRail {
+				L5[i] |= 0x20 | (tile.UnderBridge-UnderBridgeRail)&1<<3 // rail or road
+			} else {
+				L5[i] |= (tile.UnderBridge & 1) << 3 // land or water
+			}
+		} else if !kept {
 			return fmt.Errorf("Unsupported tile class %x\n", tile.Class)
 		}
 	}
@@ -164,10 +585,8 @@ func (s *Savegame) Save(f OutFile) error {
 		return err
 	}
 
-	err = s.writeCompressed(f, slices.Concat(w(s.Days), w(s.FractionalDays)))
-	if err != nil {
-		return err
-	}
+	data := make([]byte, 0, uncompressedSize)
+	data = slices.Concat(data, w(s.Days), w(s.FractionalDays))
 
 	for i := range 0x1e {
 		e := get[TextEffect](s.TextEffects, i, TextEffect{ID: 0xFFFF}) // 0xFFFF denotes empty
@@ -176,40 +595,34 @@ func (s *Savegame) Save(f OutFile) error {
 		if err != nil {
 			return err
 		}
-		err = s.writeCompressed(f, b)
-		if err != nil {
-			return err
-		}
+		data = append(data, b...)
 	}
 
-	err = s.writeCompressed(f, ll(s.Seed))
-	if err != nil {
-		return err
-	}
+	data = append(data, ll(s.Seed)...)
 
-	var customStrings []string
+	strs := newCustomStrings(s)
 	for i := range 0x46 {
 		t := get[Town](s.Towns, i, Town{})
-		name := uint16(len(customStrings)) + firstCustomTextID
-		err = s.writeCompressed(f, slices.Concat(b(t.X), b(t.Y), w(t.Population), w(name), slices.Repeat([]byte{0}, townPlaceholder)))
-		if err != nil {
-			return err
+		name := t.NameID
+		if t.X != 0 || t.Y != 0 || name != 0 {
+			var err error
+			name, err = strs.add(name, t.Name)
+			if err != nil {
+				return err
+			}
 		}
-		customStrings = append(customStrings, t.Name)
+		data = slices.Concat(data, b(t.X), b(t.Y), w(t.Population), w(name), unparsed(u.TownTails, i, townPlaceholder))
 	}
 
 	for i := range 0x1388 {
 		c := get[uint16](s.Schedules, i, 0)
-		s.writeCompressed(f, w(c))
+		data = append(data, w(c)...)
 	}
 	for i := range 0x100 {
 		c := get[uint16](s.Animations, i, 0)
-		s.writeCompressed(f, w(c))
-	}
-	err = s.writeCompressed(f, l(uint32(len(s.Schedules))))
-	if err != nil {
-		return err
+		data = append(data, w(c)...)
 	}
+	data = append(data, orDefault(u.SchedulesEnd, l(uint32(len(s.Schedules))))...)
 
 	for i := range 0xff {
 		d := get[Depot](s.Depots, i, Depot{})
@@ -218,60 +631,69 @@ func (s *Savegame) Save(f OutFile) error {
 		if err != nil {
 			return err
 		}
-		err = s.writeCompressed(f, b)
-		if err != nil {
-			return err
-		}
+		data = append(data, b...)
 	}
 
-	err = s.writeCompressed(f, slices.Concat(
+	data = slices.Concat(data,
 		l(s.NextProcessedTown),
 		w(s.AnimationTicker),
 		w(s.LandscapeCode),
 		w(s.AgeTicker),
 		w(s.AnotherAnimationTicker),
 		w(s.NextProcessedXY),
-		slices.Repeat([]byte{0}, placeholder1),
+		orDefault(u.CostsAndCargo, make([]byte, placeholder1)),
 		L1,
 		L2,
 		L3,
-		desert,
-		slices.Repeat([]byte{0}, placeholder2))) // placeholder for stations, industry
-	if err != nil {
-		return err
+		desert)
+
+	for i := range maxStations {
+		st := get[Station](s.Stations, i, Station{})
+		name := st.NameID
+		if st.X != 0 || st.Y != 0 || name != 0 {
+			var err error
+			name, err = strs.add(name, st.Name)
+			if err != nil {
+				return err
+			}
+		}
+		data = append(data, stationBytes(st, name, unparsed(u.Stations, i, stationSize))...)
+	}
+
+	for i := range maxIndustries {
+		data = append(data, industryBytes(get[Industry](s.Industries, i, Industry{}), unparsed(u.Industries, i, industrySize))...)
 	}
 
 	for i := range 8 {
 		c := get[Company](s.Companies, i, Company{})
-		name := uint16(0)
-		manager := uint16(0)
-		if i < len(s.Companies) {
-			name = uint16(len(customStrings)) + firstCustomTextID
-			customStrings = append(customStrings, c.Name)
-			manager = uint16(len(customStrings)) + firstCustomTextID
-			customStrings = append(customStrings, c.ManagerName)
+		name, manager := c.NameID, c.ManagerNameID
+		if name != 0 || c.Name != "" {
+			var err error
+			name, err = strs.add(name, c.Name)
+			if err != nil {
+				return err
+			}
+			manager, err = strs.add(manager, c.ManagerName)
+			if err != nil {
+				return err
+			}
 		}
-		err = s.writeCompressed(f, slices.Concat(w(name), l(c.NameParts), l(c.Face), w(manager), l(c.ManagerNameParts), slices.Repeat([]byte{0}, 0x3b2-16)))
+		data = slices.Concat(data, w(name), l(c.NameParts), l(c.Face), w(manager), l(c.ManagerNameParts), unparsed(u.CompanyBodies, i, 0x3b2-16))
 	}
 
-	customStringsBytes := make([]byte, 0, 0x20*0x1f4)
-	for _, str := range customStrings {
-		c := []byte(str)
-		if len(c) > 0x20 {
-			return fmt.Errorf("Custom string %q exceeds maximum length %d\n", c, 0x20)
-		}
-		customStringsBytes = append(customStringsBytes, pad(c, 0x20)...)
+	vehicles := make([]byte, 0, vehicleSize*maxVehicles)
+	for i := range maxVehicles {
+		vehicles = append(vehicles, vehicleBytes(get[Vehicle](s.Vehicles, i, Vehicle{}), unparsed(u.Vehicles, i, vehicleSize))...)
 	}
-	customStringsBytes = pad(customStringsBytes, 0x20*0x1f4)
+	blocks := hashVehicles(vehicles)
 
-	err = s.writeCompressed(f, slices.Concat(
-		slices.Repeat([]byte{0}, placeholder3), // vehicles
-		customStringsBytes,
-		slices.Repeat([]byte{0xff, 0xff}, 0x1000), // vehicles in bounding blocks
-		slices.Repeat([]byte{0x00}, 0xe*0x28),     // signs
-		slices.Repeat([]byte{0x00}, 0x1c*0x100),   // vehicle types
+	data = slices.Concat(data,
+		vehicles,
+		strs.data,
+		blocks,
+		orDefault(u.SignsAndVehicleTypes, make([]byte, placeholder4)),
 		w(s.NextVehicleArray),
-		slices.Repeat([]byte{0xFF, 0, 0, 0}, 8), // subsidies
+		orDefault(u.Subsidies, slices.Repeat([]byte{0xFF, 0, 0, 0}, 8)),
 		w(s.AICompanyTicks),
 		w(s.MainViewX),
 		w(s.MainViewY),
@@ -280,7 +702,7 @@ func (s *Savegame) Save(f OutFile) error {
 		l(s.MaximumLoanInternal),
 		w(s.RecessionCounter),
 		w(s.DaysUntilDisaster),
-		slices.Repeat([]byte{0}, placeholder5), // placeholder for pointers to text IDs
+		orDefault(u.TextPointers, make([]byte, placeholder5)),
 		b(s.Player1Company),
 		b(s.Player2Company),
 		b(s.NextStationTick),
@@ -289,7 +711,7 @@ func (s *Savegame) Save(f OutFile) error {
 		b(s.NextCompanyTick),
 		b(s.Year),
 		b(s.Month),
-		slices.Repeat([]byte{0}, 8),
+		orDefault(u.AfterDate, make([]byte, 8)),
 		b(s.Inflation),
 		b(s.CargoInflation),
 		b(s.InterestRate),
@@ -318,10 +740,12 @@ func (s *Savegame) Save(f OutFile) error {
 		b(s.TreeTicker),
 		bools([]bool{s.CustomVehicleNames, s.CustomVehicleNamesCanBeChanged}),
 		b(s.SnowLine),
-		slices.Repeat([]byte{0}, placeholder6),
+		orDefault(u.IndustryAndCargoTypes, make([]byte, placeholder6)),
 		L4,
 		L5,
-	))
+	)
+
+	err = s.writeCompressed(f, data)
 	if err != nil {
 		return err
 	}
@@ -1,7 +1,17 @@
 package ttd
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"testing/iotest"
+
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"slices"
 	"strings"
 	"testing"
@@ -16,6 +26,20 @@ func (f *fakeOutFile) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+type bytesFile struct {
+	data  []byte
+	index int
+}
+
+func (f *bytesFile) Read(b []byte) (int, error) {
+	if f.index == len(f.data) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.data[f.index:])
+	f.index += n
+	return n, nil
+}
+
 func pads(s string, l int) string {
 	return s + strings.Repeat(" ", l-len(s))
 }
@@ -31,8 +55,31 @@ func TestSaveAndLoad(t *testing.T) {
 		},
 		Seed: 2 ^ 40,
 		Towns: []Town{
-			Town{X: 54, Y: 55, Population: 56, Name: pads("Town1", 0x20)},
-			Town{X: 57, Y: 58, Population: 59, Name: pads("Town2", 0x20)},
+			Town{X: 54, Y: 55, Population: 56, Name: pads("Town1", 0x20), NameID: firstCustomTextID + 1},
+			Town{X: 57, Y: 58, Population: 59, Name: pads("Town2", 0x20), NameID: firstCustomTextID},
+		},
+		Industries: []Industry{
+			Industry{X: 100, Y: 101, Town: 1, Width: 3, Height: 3, ProducedCargo: [2]uint8{1, 0xff}, ProducedCargoWaiting: [2]uint16{102, 103},
+				ProductionRate: [2]uint8{13, 0}, AcceptedCargo: [3]uint8{0xff, 0xff, 0xff}, ProductionLevel: 16,
+				ThisMonthProduction: [2]uint16{104, 105}, ThisMonthTransported: [2]uint16{106, 107}, LastMonthPctTransported: [2]uint8{108, 109},
+				LastMonthProduction: [2]uint16{110, 111}, LastMonthTransported: [2]uint16{112, 113}, Type: 0, Owner: 0x10, Colour: 114,
+				LastProductionYear: 115, Counter: 116, WasCargoDelivered: 1},
+		},
+		Stations: []Station{
+			Station{X: 120, Y: 121, Town: 1, Name: pads("Station1", 0x20), NameID: firstCustomTextID + 7, Rail: 122 | 121<<8, Platforms: 2<<3 | 4, Facilities: StationRail,
+				HadVehicleOfType: 1, Cargo: [12]StationCargo{0: {Waiting: 123, Accepted: true, DaysSincePickup: 124, Rating: 175, Days: 125, LastSpeed: 126, LastAge: 127}},
+				TimeSinceLoad: 128, TimeSinceUnload: 129, DeleteCounter: 130, Owner: 0},
+			Station{}, // empty slot
+			Station{X: 3, Y: 4, NameID: 0x300c, BusStop: 5 | 4<<8, Dock: 6 | 4<<8, Airport: 7 | 4<<8, AirportType: 1, AirportFlags: 0x8001,
+				Facilities: StationBus | StationDock | StationAirport, Owner: 1},
+		},
+		Vehicles: []Vehicle{
+			Vehicle{Type: VehicleTrain, Subtype: 0, Orders: 0, NumberOfOrders: 2, CurrentOrder: 0x1234, Destination: 1, X: 120*16 + 8, Y: 121*16 + 5,
+				Z: 8, Direction: 3, Owner: 0, Tile: 121<<8 | 120, Status: 2, Speed: 140, CargoType: 0xff, Age: 131, MaxAge: 132, BuildYear: 133,
+				UnitNumber: 1, Engine: 134, Reliability: 0xf000, ReliabilityDecay: 135, ProfitThisYear: -136, ProfitLastYear: 137, Next: 2, Value: 138},
+			Vehicle{}, // empty slot
+			Vehicle{Type: VehicleTrain, Subtype: 2, X: 120*16 + 8, Y: 122*16 + 5, Z: 8, Direction: 3, Owner: 0, Tile: 122<<8 | 120,
+				CargoType: 1, CargoCapacity: 30, Cargo: 20, CargoSource: 0, CargoDays: 7, Next: NoVehicle},
 		},
 		Schedules:  []uint16{60, 61},
 		Animations: []uint16{62, 63, 64},
@@ -46,7 +93,7 @@ func TestSaveAndLoad(t *testing.T) {
 		AgeTicker:                      17,
 		AnotherAnimationTicker:         18,
 		NextProcessedXY:                19,
-		Companies:                      []Company{Company{Name: pads("Company", 0x20), NameParts: 65, Face: 66, ManagerName: pads("Manager", 0x20), ManagerNameParts: 67}},
+		Companies:                      []Company{Company{Name: pads("Company", 0x20), NameID: firstCustomTextID + 2, NameParts: 65, Face: 66, ManagerName: pads("Manager", 0x20), ManagerNameID: firstCustomTextID + 3, ManagerNameParts: 67}},
 		NextVehicleArray:               20,
 		AICompanyTicks:                 21,
 		MainViewX:                      22,
@@ -98,6 +145,24 @@ func TestSaveAndLoad(t *testing.T) {
 		SnowLine:                       53,
 		Tiles:                          slices.Repeat([]Tile{Tile{Class: 0, Height: 1, Owner: 2, Type: 3}}, 0x10000),
 	}
+	want.Tiles[1] = Tile{Class: 1, Height: 2, Owner: 0, Type: TrackX | TrackUpper, Ground: 1}                       // rail
+	want.Tiles[2] = Tile{Class: 1, Height: 2, Owner: 0x10, Type: TrackLeft, Ground: 12}                             // rail in snow
+	want.Tiles[3] = Tile{Class: 2, Height: 3, Owner: 0x10, Type: 10}                                                // road
+	want.Tiles[4] = Tile{Class: 2, Height: 3, Owner: 0x10, Crossing: true, Axis: 1, RailOwner: 0}                   // level crossing
+	want.Tiles[5] = Tile{Class: 9, Height: 4, Owner: 0x10, Tunnel: true, Transport: 1, Direction: 2}                // tunnel
+	want.Tiles[6] = Tile{Class: 9, Height: 1, Owner: 0, BridgeEnd: 1, BridgeType: 2, Axis: 1}                       // bridge head
+	want.Tiles[7] = Tile{Class: 9, Owner: 0, BridgeType: 2, BridgePiece: 4, UnderBridge: UnderBridgeWater, Axis: 1} // bridge
+	want.Tiles[8] = Tile{Class: 9, Height: 1, Owner: 0, BridgeEnd: 2, BridgeType: 2, Axis: 1}                       // bridge head
+	want.Tiles[9] = Tile{Class: 8, Height: 1, Industry: 0, Type: 5, Stage: 3}                                       // industry
+	want.Tiles[10] = Tile{Class: 4, Height: 1, Owner: 0x10, Type: 12, Trees: 4, Growth: 3, Ground: 2, Density: 3}   // trees in snow
+
+	want.Tiles[11] = Tile{Class: 0, Height: 1, Owner: 0x10, Type: ClearFields<<2 | 3, Field: 5, HedgeSE: 3, HedgeSW: 1} // fields
+	want.Tiles[12] = Tile{Class: 5, Height: 1, Owner: 0, Station: 0, Type: 1}                                           // rail station
+	want.Tiles[13] = Tile{Class: 5, Height: 1, Owner: 1, Station: 2, Type: 0x47}                                        // bus stop
+	want.TropicZones = make([]uint8, 0x10000)
+	want.TropicZones[1] = TropicDesert
+	want.TropicZones[6] = TropicRainforest
+	want.TropicZones[0xffff] = TropicDesert
 
 	out := &fakeOutFile{}
 	err := want.Save(out)
@@ -110,8 +175,76 @@ func TestSaveAndLoad(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !cmp.Equal(want, got) {
-		t.Errorf("Diff: %v", cmp.Diff(want, got))
+	ignoreUnparsed := cmpopts.IgnoreFields(Savegame{}, "Unparsed")
+	if !cmp.Equal(want, got, ignoreUnparsed) {
+		t.Errorf("Diff: %v", cmp.Diff(want, got, ignoreUnparsed))
+	}
+}
+
+func TestHashVehicles(t *testing.T) {
+	// screen coordinates of x, y, z are 2 * (y - x), x + y - z
+	v := Vehicle{Type: VehicleRoad, X: 16, Y: 16 + 64, Z: 0, Next: NoVehicle}
+	vehicles := make([]byte, vehicleSize*maxVehicles)
+	set := func(i int, v Vehicle) {
+		copy(vehicles[i*vehicleSize:], vehicleBytes(v, vehicles[i*vehicleSize:(i+1)*vehicleSize]))
+	}
+	set(3, v)
+	set(5, v)
+	set(7, Vehicle{Type: VehicleShip, X: 16, Y: 16, Z: 0, Next: NoVehicle})
+	hashNext := func(i int) uint16 {
+		return uint16(vehicles[i*vehicleSize+2]) | uint16(vehicles[i*vehicleSize+3])<<8
+	}
+	first := func(blocks []byte, b int) uint16 {
+		return uint16(blocks[2*b]) | uint16(blocks[2*b+1])<<8
+	}
+
+	blocks := hashVehicles(vehicles)
+	// 3 and 5 at 128, 96, 7 at 0, 32
+	if got := first(blocks, 1<<6|1); got != 3 {
+		t.Errorf("Got vehicle %d in the block of 3 and 5", got)
+	}
+	if got := hashNext(3); got != 5 {
+		t.Errorf("Got %d after 3, wanted 5", got)
+	}
+	if got := hashNext(5); got != NoVehicle {
+		t.Errorf("Got %d after 5, wanted none", got)
+	}
+	if got := first(blocks, 0); got != 7 {
+		t.Errorf("Got vehicle %d in the block of 7", got)
+	}
+	if n := bytes.Count(blocks, []byte{0xff, 0xff}); n != boundingBlocks-2 {
+		t.Errorf("Got %d empty blocks, wanted %d", n, boundingBlocks-2)
+	}
+
+	// moving 5 to x 128, y 16 puts it at -224, 144
+	v.X, v.Y = 128, 16
+	set(5, v)
+	blocks = hashVehicles(vehicles)
+	if got := first(blocks, 2<<6|(-224>>7&0x3f)); got != 5 {
+		t.Errorf("Got vehicle %d in the block that 5 moved to", got)
+	}
+	if got := hashNext(3); got != NoVehicle {
+		t.Errorf("Got %d after 3 after moving 5 away, wanted none", got)
+	}
+}
+
+func TestVehicleOrders(t *testing.T) {
+	slot := make([]byte, vehicleSize)
+	if got := vehicleFromBytes(slot).Orders; got != 0 {
+		t.Errorf("Got orders %d for a vehicle without an order pointer, want 0", got)
+	}
+	pointer := func(d []byte) uint32 {
+		return uint32(d[6]) | uint32(d[7])<<8 | uint32(d[8])<<16 | uint32(d[9])<<24
+	}
+	if got := pointer(vehicleBytes(Vehicle{Type: VehicleRoad}, slot)); got != 0 {
+		t.Errorf("Got order pointer %#x for a vehicle without orders, want 0", got)
+	}
+	d := vehicleBytes(Vehicle{Type: VehicleRoad, NumberOfOrders: 2}, slot)
+	if got := pointer(d); got != orderPointerBase {
+		t.Errorf("Got order pointer %#x for the first orders, want %#x", got, orderPointerBase)
+	}
+	if got := vehicleFromBytes(d).Orders; got != 0 {
+		t.Errorf("Got orders %d, want 0", got)
 	}
 }
 
@@ -119,7 +252,7 @@ func TestReadCompressed(t *testing.T) {
 	in := &bytesFile{data: []byte{0xFD, 42, 1, 3, 4}}
 	want := []byte{42, 42, 42, 42, 3, 4}
 	s := Savegame{}
-	got, err := s.readCompressed(in, len(want))
+	got, err := s.readCompressed(bufio.NewReader(in), len(want))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -127,3 +260,482 @@ func TestReadCompressed(t *testing.T) {
 		t.Errorf("Got %v, wanted %v", got, want)
 	}
 }
+
+func TestUnderBridge(t *testing.T) {
+	s := &Savegame{
+		Title:          pads("bridges", maxTitleLength),
+		MaxInitialLoan: 50000,
+		Tiles:          slices.Repeat([]Tile{Tile{Height: 1, Owner: 0x10, Type: 3}}, NumberOfTiles),
+	}
+	// road bridges along y over each kind of tile
+	want := map[uint8]uint8{
+		UnderBridgeLand:  0xc3,
+		UnderBridgeWater: 0xcb,
+		UnderBridgeRail:  0xe3,
+		UnderBridgeRoad:  0xeb,
+	}
+	for under := range want {
+		s.Tiles[under] = Tile{Class: 9, Owner: 0x10, BridgeType: 2, BridgePiece: 1, UnderBridge: under, Transport: 1, Axis: 1}
+	}
+	out := &fakeOutFile{}
+	if err := s.Save(out); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(&bytesFile{data: out.written})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for under, l5 := range want {
+		if got.Unparsed.L5[under] != l5 {
+			t.Errorf("L5 of a bridge over %d is %#x, wanted %#x", under, got.Unparsed.L5[under], l5)
+		}
+		if got.Tiles[under].UnderBridge != under {
+			t.Errorf("Bridge over %d loaded over %d", under, got.Tiles[under].UnderBridge)
+		}
+	}
+}
+
+func TestTropicZones(t *testing.T) {
+	newSavegame := func(zones []uint8) *Savegame {
+		return &Savegame{
+			Title:          pads("zones", maxTitleLength),
+			MaxInitialLoan: 50000,
+			LandscapeType:  2,
+			Tiles:          slices.Repeat([]Tile{Tile{Height: 1, Owner: 0x10, Type: 3}}, NumberOfTiles),
+			TropicZones:    zones,
+		}
+	}
+	every := make([]uint8, NumberOfTiles)
+	for i := range every {
+		every[i] = uint8(i*7/5) % 3 // all zones next to each other in the same byte
+	}
+	for _, zones := range [][]uint8{nil, every} {
+		out := &fakeOutFile{}
+		if err := newSavegame(zones).Save(out); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Load(&bytesFile{data: out.written})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(zones, got.TropicZones) {
+			t.Errorf("Diff: %v", cmp.Diff(zones, got.TropicZones))
+		}
+	}
+
+	if err := newSavegame(make([]uint8, 10)).Validate(); err == nil {
+		t.Errorf("Validate accepted 10 tropic zones")
+	}
+}
+
+func TestLosslessRoundTrip(t *testing.T) {
+	s := &Savegame{
+		Title:          pads("lossless", maxTitleLength),
+		MaxInitialLoan: 50000,
+		Towns:          []Town{{X: 1, Y: 2, Name: "Town"}},
+		Companies:      []Company{{Name: "Company", ManagerName: "Manager"}},
+		Schedules:      []uint16{1, 2, 0, 3},
+		Tiles:          slices.Repeat([]Tile{Tile{Height: 1, Owner: 0x10, Type: 3}}, NumberOfTiles),
+	}
+	s.Tiles[1] = Tile{Class: 1, Height: 1, Owner: 0x10, Type: TrackX, Ground: 1}
+	save := func(s *Savegame) []byte {
+		out := &fakeOutFile{}
+		if err := s.Save(out); err != nil {
+			t.Fatal(err)
+		}
+		return out.written
+	}
+	loaded, err := Load(&bytesFile{data: save(s)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make it look like a real game, with data in all the regions that
+	// aren't decoded
+	r := rand.New(rand.NewPCG(1, 2))
+	u := &loaded.Unparsed
+	for _, region := range [][]byte{u.TownTails, u.SchedulesEnd, u.CostsAndCargo, u.CompanyBodies, u.SignsAndVehicleTypes, u.Subsidies, u.TextPointers, u.AfterDate, u.IndustryAndCargoTypes} {
+		for i := range region {
+			region[i] = byte(r.Uint32())
+		}
+	}
+	for i := 0; i < len(u.Stations); i += stationSize {
+		for _, o := range []int{0x11, 0x12, 0x13, 0x16, 0x17, 0x18, 0x19, 0x82, 0x83, 0x84, 0x85, 0x88, 0x89, 0x8a, 0x8b, 0x8c, 0x8d} {
+			u.Stations[i+o] = byte(r.Uint32())
+		}
+	}
+	for i := 0; i < len(u.Industries); i += industrySize {
+		for j := 0x2d; j < industrySize; j++ {
+			u.Industries[i+j] = byte(r.Uint32())
+		}
+	}
+	copy(u.CustomStrings[0x20*0x10:], "Sign") // not the name of anything
+	for i := 0; i < len(u.Vehicles); i += vehicleSize {
+		for _, o := range [][2]int{{0x02, 0x06}, {0x10, 0x1a}, {0x20, 0x25}, {0x28, 0x32}, {0x36, 0x39}, {0x48, 0x4e}, {0x60, 0x80}} {
+			for j := o[0]; j < o[1]; j++ {
+				u.Vehicles[i+j] = byte(r.Uint32())
+			}
+		}
+	}
+	u.L1[2], u.L2[2], u.L3[2*2], u.L3[2*2+1], u.L4[2], u.L5[2] = 1, 2, 3, 4, 0xa1, 6
+	loaded.Tiles[2] = Tile{Class: 0xa, Height: 1} // unmovable, which isn't supported
+	_, data, _, err := Uncompress(&bytesFile{data: save(loaded)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// empty slots between used ones, which have to stay where they are
+	const (
+		textEffects = 4
+		animations  = textEffects + 0x14*0x1e + 8 + 0x5e*0x46 + 2*0x1388
+		depots      = animations + 2*0x100 + 4
+		l1          = depots + 6*0xff + 14 + placeholder1
+		l2          = l1 + NumberOfTiles
+		l3          = l2 + NumberOfTiles
+	)
+	for i, e := range [][]byte{
+		{0xff, 0xff, 1, 2, 3, 4, 5, 6, 7, 8, 9}, // empty with stale data
+		{1, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18},
+		{0xff, 0xff},
+		{2, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18},
+	} {
+		copy(data[textEffects+0x14*i:], e)
+	}
+	copy(data[animations:], []byte{0, 0, 0x34, 0x12, 0, 0, 0x45, 0x23})
+	copy(data[depots:], []byte{0, 0, 0, 0, 0, 0, 0x02, 0x01, 0x64, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0x04, 0x03, 0xc2, 0x02, 0, 0})
+
+	// tiles with bits that aren't decoded
+	l4, l5 := len(data)-2*NumberOfTiles, len(data)-NumberOfTiles
+	for i, tile := range []struct {
+		l1, l2, l3a, l3b, l4, l5 byte
+	}{
+		{0x10, 0, 0xf2, 0x6f, 0x01, 0x63}, // clear land with an update counter
+		{0x00, 0x31, 0x55, 0x66, 0x11, 0x01},
+		{0x01, 0x55, 0x66, 0x77, 0x21, 0x22}, // road depot
+		{0x10, 0x55, 0x01, 0x02, 0x21, 0x05},
+		{0x10, 0x9b, 0x03, 0x04, 0x41, 0x7a}, // trees with a growth counter
+		{0x02, 0x00, 0x05, 0x06, 0x60, 0x83}, // ship depot
+		{0x11, 0x00, 0x00, 0x00, 0x60, 0x01},
+		{0x9f, 0x00, 0x07, 0x08, 0x81, 0x21}, // industry with a construction counter
+	} {
+		i += 0x1000
+		data[l1+i], data[l2+i], data[l3+2*i], data[l3+2*i+1], data[l4+i], data[l5+i] = tile.l1, tile.l2, tile.l3a, tile.l3b, tile.l4, tile.l5
+	}
+
+	want := savegameFile(t, data)
+	game, err := Load(&bytesFile{data: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(game.TextEffects) != 4 || len(game.Animations) != 4 || len(game.Depots) != 4 {
+		t.Errorf("Got %d text effects, %d animations and %d depots, want 4 each with the empty slots", len(game.TextEffects), len(game.Animations), len(game.Depots))
+	}
+	if tile := game.Tiles[0x1002]; !tile.Depot || tile.Direction != 2 || tile.Owner != 1 {
+		t.Errorf("Got road tile %+v, want a depot", tile)
+	}
+	if tile := game.Tiles[0x1005]; !tile.Depot || tile.Axis != 1 || tile.DepotPart != 1 || tile.Owner != 2 {
+		t.Errorf("Got water tile %+v, want a ship depot", tile)
+	}
+	got := save(game)
+	if !slices.Equal(want, got) {
+		_, want, _, _ := Uncompress(&bytesFile{data: want})
+		_, got, _, _ := Uncompress(&bytesFile{data: got})
+		i := 0
+		for i < min(len(want), len(got)) && want[i] == got[i] {
+			i++
+		}
+		t.Errorf("Saved game differs from the loaded one at offset %#x", i)
+	}
+}
+
+// savegameFile returns a savegame file with the uncompressed data.
+func savegameFile(t *testing.T, data []byte) []byte {
+	s := &Savegame{}
+	out := &fakeOutFile{}
+	title := pad([]byte("raw"), maxTitleLength)
+	if err := s.writeUncompressed(out, slices.Concat(title, w(titleChecksum(title)))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.writeCompressed(out, data); err != nil {
+		t.Fatal(err)
+	}
+	out.Write(l(s.Checksum + fileChecksumAdd))
+	return out.written
+}
+
+func TestEmptySlotsRoundTrip(t *testing.T) {
+	s := &Savegame{
+		Title:          pads("empty slots", maxTitleLength),
+		MaxInitialLoan: 50000,
+		Towns:          []Town{{}, {X: 1, Y: 2, Name: "B"}},
+		Industries:     []Industry{{}, {X: 5, Y: 6, Town: 1, Type: 3}},
+		Stations:       []Station{{X: 3, Y: 3, Town: 1, Name: "S"}},
+		Companies:      []Company{{}, {Name: "C", ManagerName: "M"}},
+		Tiles:          slices.Repeat([]Tile{Tile{Height: 1, Owner: 0x10, Type: 3}}, NumberOfTiles),
+	}
+	save := func(s *Savegame) []byte {
+		out := &fakeOutFile{}
+		if err := s.Save(out); err != nil {
+			t.Fatal(err)
+		}
+		return out.written
+	}
+	load := func(data []byte) *Savegame {
+		s, err := Load(&bytesFile{data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	name := func(s string) string {
+		return strings.TrimRight(s, "\x00")
+	}
+
+	loaded := load(save(s))
+	if len(loaded.Towns) != 2 || loaded.Towns[0].X != 0 || name(loaded.Towns[1].Name) != "B" {
+		t.Fatalf("Got towns %+v, want an empty slot and B", loaded.Towns)
+	}
+	if len(loaded.Industries) != 2 || loaded.Industries[1].X != 5 || loaded.Industries[1].Town != 1 {
+		t.Fatalf("Got industries %+v, want an empty slot and one of town 1", loaded.Industries)
+	}
+	if len(loaded.Companies) != 2 || loaded.Companies[0].NameID != 0 || name(loaded.Companies[1].Name) != "C" || name(loaded.Companies[1].ManagerName) != "M" {
+		t.Fatalf("Got companies %+v, want an empty slot and C", loaded.Companies)
+	}
+
+	// every slot has its own undecoded bytes, so a slot that moves shows
+	r := rand.New(rand.NewPCG(7, 8))
+	for _, region := range [][]byte{loaded.Unparsed.TownTails, loaded.Unparsed.CompanyBodies} {
+		for i := range region {
+			region[i] = byte(r.Uint32())
+		}
+	}
+	copy(loaded.Unparsed.CustomStrings[0x20*4:], "Sign")
+	want := save(loaded)
+	game := load(want)
+	ignoreChecksum := cmpopts.IgnoreFields(Savegame{}, "Checksum")
+	if !cmp.Equal(loaded, game, ignoreChecksum) {
+		t.Errorf("Diff: %v", cmp.Diff(loaded, game, ignoreChecksum))
+	}
+	_, want, _, err := Uncompress(&bytesFile{data: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got, _, err := Uncompress(&bytesFile{data: save(game)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(want, got) {
+		i := 0
+		for want[i] == got[i] {
+			i++
+		}
+		t.Errorf("Saved game differs from the loaded one at offset %#x", i)
+	}
+
+	// a new name gets a custom string that isn't used yet
+	game.Towns = append(game.Towns, Town{X: 7, Y: 8, Name: "New"})
+	game = load(save(game))
+	if got := game.Towns[2]; name(got.Name) != "New" || got.NameID != firstCustomTextID+5 {
+		t.Errorf("Got new town %q with text ID %#x, want New with %#x", got.Name, got.NameID, firstCustomTextID+5)
+	}
+	if got := name(string(game.Unparsed.CustomStrings[0x20*4 : 0x20*5])); got != "Sign" {
+		t.Errorf("Got custom string %q, want Sign", got)
+	}
+	if got := name(game.Towns[1].Name); got != "B" {
+		t.Errorf("Got town %q, want B", got)
+	}
+}
+
+// shortestEncoding returns the size of the shortest encoding of data by
+// trying all chunks at every position.
+func shortestEncoding(data []byte) int {
+	cost := make([]int, len(data)+1)
+	for i := len(data) - 1; i >= 0; i-- {
+		cost[i] = math.MaxInt
+		for k := 1; k <= maxLiteral && i+k <= len(data); k++ {
+			cost[i] = min(cost[i], 1+k+cost[i+k])
+		}
+		for k := 2; k <= maxRepeat && i+k <= len(data) && data[i+k-1] == data[i]; k++ {
+			cost[i] = min(cost[i], 2+cost[i+k])
+		}
+	}
+	return cost[0]
+}
+
+func TestCompress(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	tests := map[string][]byte{
+		"empty":       {},
+		"one byte":    {7},
+		"two same":    {7, 7},
+		"long run":    make([]byte, 1000),
+		"runs of 129": slices.Concat(slices.Repeat([]byte{1}, 129), slices.Repeat([]byte{2}, 130), slices.Repeat([]byte{3}, 131)),
+		"literals":    make([]byte, 300),
+	}
+	for i := range tests["literals"] {
+		tests["literals"][i] = byte(i)
+	}
+	for i := range 50 {
+		// few different bytes, so that there are many short runs
+		data := make([]byte, r.IntN(600))
+		for j := range data {
+			if j == 0 || r.IntN(3) == 0 {
+				data[j] = byte(r.IntN(3))
+			} else {
+				data[j] = data[j-1]
+			}
+		}
+		tests[fmt.Sprintf("random %d", i)] = data
+	}
+
+	for name, data := range tests {
+		compressed := compress(data)
+		s := Savegame{}
+		got, err := s.readCompressed(bufio.NewReader(bytes.NewReader(compressed)), len(data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !slices.Equal(data, got) {
+			t.Errorf("%s: decompressed data differs", name)
+		}
+		if want := shortestEncoding(data); len(compressed) != want {
+			t.Errorf("%s: compressed to %d bytes, shortest is %d", name, len(compressed), want)
+		}
+	}
+}
+
+// compressLiterals is the encoding without repeats, for comparison.
+func compressLiterals(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); i += maxLiteral {
+		chunk := data[i:min(i+maxLiteral, len(data))]
+		out = append(out, byte(len(chunk)-1))
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+// benchmarkData returns the uncompressed data of a savegame with some
+// variety in the tiles.
+func benchmarkData(b *testing.B) []byte {
+	s := &Savegame{
+		Title:          pads("benchmark", maxTitleLength),
+		MaxInitialLoan: 50000,
+		Towns:          []Town{{X: 10, Y: 10, Name: "Town"}},
+		Tiles:          slices.Repeat([]Tile{Tile{Height: 1, Owner: 0x10, Type: 3}}, NumberOfTiles),
+	}
+	r := rand.New(rand.NewPCG(5, 6))
+	for i := range s.Tiles {
+		s.Tiles[i].Height = uint8(i / 256 / 32)
+		if r.IntN(10) == 0 {
+			s.Tiles[i] = Tile{Class: 4, Height: s.Tiles[i].Height, Owner: 0x10, Type: uint8(r.IntN(12)), Trees: 1 + uint8(r.IntN(4)), Growth: 3, Density: 3}
+		}
+	}
+	out := &fakeOutFile{}
+	if err := s.Save(out); err != nil {
+		b.Fatal(err)
+	}
+	_, data, _, err := Uncompress(&bytesFile{data: out.written})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func BenchmarkCompress(b *testing.B) {
+	data := benchmarkData(b)
+	for _, bm := range []struct {
+		name     string
+		compress func([]byte) []byte
+	}{{"rle", compress}, {"literals", compressLiterals}} {
+		b.Run(bm.name, func(b *testing.B) {
+			var out []byte
+			for range b.N {
+				out = bm.compress(data)
+			}
+			b.SetBytes(int64(len(data)))
+			b.ReportMetric(float64(len(out)), "bytes")
+		})
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	data := benchmarkData(b)
+	compressed := compress(data)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for range b.N {
+		s := Savegame{}
+		if _, err := s.readCompressed(bufio.NewReader(bytes.NewReader(compressed)), len(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLoadShortReads(t *testing.T) {
+	want := &Savegame{
+		Title:          pads("short reads", maxTitleLength),
+		MaxInitialLoan: 50000,
+		Towns:          []Town{{X: 1, Y: 2, Name: string(pad([]byte("Town"), 0x20)), NameID: firstCustomTextID}},
+		Tiles:          slices.Repeat([]Tile{Tile{Height: 1, Owner: 0x10, Type: 3}}, NumberOfTiles),
+	}
+	out := &bytes.Buffer{}
+	if err := want.Save(out); err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+
+	for name, r := range map[string]io.Reader{
+		"one byte at a time": iotest.OneByteReader(bytes.NewReader(data)),
+		"half reads":         iotest.HalfReader(bytes.NewReader(data)),
+		"eof with data":      iotest.DataErrReader(bytes.NewReader(data)),
+	} {
+		got, err := Load(r)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		ignoreUnparsed := cmpopts.IgnoreFields(Savegame{}, "Unparsed")
+		if !cmp.Equal(want, got, ignoreUnparsed) {
+			t.Errorf("%s: Diff: %v", name, cmp.Diff(want, got, ignoreUnparsed))
+		}
+	}
+
+	_, err := Load(iotest.OneByteReader(bytes.NewReader(data[:len(data)/2])))
+	if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		t.Errorf("truncated file: got error %v, want an EOF", err)
+	}
+}
+
+func BenchmarkLoad(b *testing.B) {
+	s := &Savegame{
+		Title:          pads("benchmark", maxTitleLength),
+		MaxInitialLoan: 50000,
+		Tiles:          slices.Repeat([]Tile{Tile{Height: 1, Owner: 0x10, Type: 3}}, NumberOfTiles),
+	}
+	out := &bytes.Buffer{}
+	if err := s.Save(out); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(out.Len()))
+	b.ResetTimer()
+	for range b.N {
+		if _, err := Load(bytes.NewReader(out.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSave(b *testing.B) {
+	s := &Savegame{
+		Title:          pads("benchmark", maxTitleLength),
+		MaxInitialLoan: 50000,
+		Tiles:          slices.Repeat([]Tile{Tile{Height: 1, Owner: 0x10, Type: 3}}, NumberOfTiles),
+	}
+	for range b.N {
+		if err := s.Save(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
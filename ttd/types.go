@@ -1,5 +1,7 @@
 package ttd
 
+import "io"
+
 type TextEffect struct {
 	ID                       uint16 // 0xFFFF if empty
 	Left, Right, Top, Bottom uint16
@@ -9,31 +11,210 @@ type TextEffect struct {
 }
 
 type Depot struct {
-	XY   uint16
+	XY   uint16 // 0 for an empty slot
 	Town uint32
 }
 
 type Town struct {
 	X, Y       uint8 // 00 for empty slot
 	Population uint16
-	Name       string
+	Name       string // custom string of NameID, or of a new one if NameID is 0
+	NameID     uint16 // text ID of the name
 }
 
 type Company struct {
-	Name             string
+	Name             string // custom string of NameID, or of a new one if NameID is 0
+	NameID           uint16 // text ID of the name, 0 with no Name for an empty slot
 	NameParts        uint32
 	Face             uint32
-	ManagerName      string
+	ManagerName      string // custom string of ManagerNameID, or of a new one if ManagerNameID is 0
+	ManagerNameID    uint16 // text ID of the manager name
 	ManagerNameParts uint32
 }
 
+type Industry struct {
+	X, Y                    uint8 // north tile, 0, 0 for an empty slot
+	Town                    uint8 // index in Towns
+	Width, Height           uint8
+	ProducedCargo           [2]uint8 // cargo types, 0xFF for none
+	ProducedCargoWaiting    [2]uint16
+	ProductionRate          [2]uint8 // produced every 256 ticks
+	AcceptedCargo           [3]uint8 // cargo types, 0xFF for none
+	ProductionLevel         uint8    // 16 = normal
+	ThisMonthProduction     [2]uint16
+	ThisMonthTransported    [2]uint16
+	LastMonthPctTransported [2]uint8
+	LastMonthProduction     [2]uint16
+	LastMonthTransported    [2]uint16
+	Type                    uint8
+	Owner                   uint8
+	Colour                  uint8
+	LastProductionYear      uint8
+	Counter                 uint16
+	WasCargoDelivered       uint8
+}
+
+type Station struct {
+	X, Y             uint8  // sign, 0, 0 for an empty slot
+	Town             uint8  // index in Towns
+	Name             string // custom string of NameID, or of a new one if NameID is 0
+	NameID           uint16 // text ID of the name
+	BusStop          uint16 // tile index, 0 for none
+	TruckStop        uint16
+	Rail             uint16 // northern tile of the platforms
+	Airport          uint16 // northern tile
+	Dock             uint16
+	Platforms        uint8 // rail: number of tracks << 3 | length
+	HadVehicleOfType uint16
+	Cargo            [12]StationCargo // by cargo type
+	TimeSinceLoad    uint8
+	TimeSinceUnload  uint8
+	DeleteCounter    uint8
+	Owner            uint8
+	Facilities       uint8 // StationRail | StationTruck | ...
+	AirportType      uint8 // 0 = small, 1 = large, 2 = heliport
+	AirportFlags     uint16
+}
+
+type StationCargo struct {
+	Waiting         uint16 // 0-4095
+	Accepted        bool
+	DaysSincePickup uint8
+	Rating          uint8 // 0-255
+	Source          uint8 // station the waiting cargo comes from
+	Days            uint8 // days in transit of the waiting cargo
+	LastSpeed       uint8
+	LastAge         uint8
+}
+
+// Station facilities
+const (
+	StationRail = 1 << iota
+	StationTruck
+	StationBus
+	StationAirport
+	StationDock
+)
+
+type Vehicle struct {
+	Type              uint8  // VehicleTrain, VehicleRoad, ..., 0 for an empty slot
+	Subtype           uint8  // train: 0 = engine, 2 = wagon, 4 = free wagon, aircraft: 0 = helicopter, 2 = plane, 4 = shadow, 6 = rotor
+	Orders            uint16 // index of the first order in Schedules, if NumberOfOrders > 0
+	NumberOfOrders    uint8
+	CurrentOrder      uint16
+	CurrentOrderIndex uint8
+	Destination       uint16 // tile index
+	X, Y              uint16 // in 1/16 of a tile
+	Z                 uint8  // 8 per height level
+	Direction         uint8  // 0 = north, 1 = north-east, ...
+	Owner             uint8
+	Tile              uint16 // tile index
+	Status            uint16 // 1 = hidden, 2 = stopped, 0x80 = crashed, ...
+	Speed             uint16
+	CargoType         uint8
+	CargoCapacity     uint16
+	Cargo             uint16 // amount of cargo
+	CargoSource       uint8  // station the cargo comes from
+	CargoDays         uint8  // days in transit of the cargo
+	Age               uint16 // in days
+	MaxAge            uint16
+	BuildYear         uint8 // since 1920
+	UnitNumber        uint8
+	Engine            uint16 // engine type
+	Reliability       uint16 // 0xffff = 100%
+	ReliabilityDecay  uint16
+	ProfitThisYear    int32
+	ProfitLastYear    int32
+	Next              uint16 // index of the next wagon, NoVehicle for the last one
+	Value             uint32
+}
+
+// Vehicle types
+const (
+	VehicleTrain = 0x10 + iota
+	VehicleRoad
+	VehicleShip
+	VehicleAircraft
+	VehicleEffect
+	VehicleDisaster
+)
+
+// NoVehicle is the Next of the last vehicle of a train.
+const NoVehicle = 0xffff
+
 type Tile struct {
-	Class  uint8
-	Type   uint8
-	Owner  uint8
-	Height uint8
+	Class     uint8
+	Type      uint8 // clear: ground type << 2 | density, rail: track bits, road: pieces, trees: species
+	Owner     uint8 // road: owner of the road, also on level crossings
+	Height    uint8
+	Ground    uint8 // rail: 0 = bare, 1 = grass, 2-11 = fences, 12 = snow or desert, trees: 0 = grass, 1 = rough, 2 = snow or desert
+	Crossing  bool  // road: level crossing, Type isn't used
+	Axis      uint8 // level crossing: 0 = road along x and rail along y, 1 = the other way, bridge: 0 = along x, 1 = along y
+	RailOwner uint8 // level crossing: owner of the rail
+	Depot     bool  // road or water: depot, Type isn't used
+	DepotPart uint8 // ship depot: 0 = northern part, 1 = southern part, Axis is its direction
+
+	// tunnel or bridge, Owner is the owner of the road or rail on it
+	Transport   uint8 // 0 = rail, 1 = road
+	Tunnel      bool  // tunnel entrance, otherwise bridge
+	Direction   uint8 // tunnel: direction into the tunnel, road depot: direction of the exit, 0 = north-east, 1 = south-east, 2 = south-west, 3 = north-west
+	BridgeEnd   uint8 // bridge: 0 = middle part, 1 = northern head, 2 = southern head
+	BridgeType  uint8 // bridge: 0 = wooden, 1 = concrete, 2 = girder steel, ...
+	BridgePiece uint8 // bridge middle part: 0-5, depending on the distance to the heads
+	UnderBridge uint8 // bridge middle part: 0 = land, 1 = water, 2 = rail, 3 = road
+
+	Station  uint8 // station: index in Stations, Type is the tile graphics, e.g. 0-7 rail, 0x43-0x46 truck stop, 0x47-0x4a bus stop, 0x4b-0x52 dock, 0x08-0x42 airport
+	Industry uint8 // industry: index in Industries, Type is the tile graphics
+	Stage    uint8 // industry: construction stage, 3 = completed
+
+	// clear land, Industry is the farm of fields or NoIndustry
+	Field   uint8 // fields: field type
+	HedgeSE uint8 // fence on the south-east edge, 0 = none, 3 = hedge
+	HedgeSW uint8 // fence on the south-west edge
+
+	Trees   uint8 // trees: number of trees, 1-4
+	Growth  uint8 // trees: growth stage, 0-2 = growing, 3 = grown, 4-6 = dying
+	Density uint8 // trees: density of the ground, 0-3
 }
 
+// NoIndustry is the Industry of fields that don't belong to a farm.
+const NoIndustry = 0xff
+
+// Clear land ground types
+const (
+	ClearGrass = iota
+	ClearRough
+	ClearRocks
+	ClearFields
+	ClearSnow
+	ClearDesert
+)
+
+// Zones of the sub-tropical climate
+const (
+	TropicNormal = iota
+	TropicDesert
+	TropicRainforest
+)
+
+// Under bridge middle parts
+const (
+	UnderBridgeLand = iota
+	UnderBridgeWater
+	UnderBridgeRail
+	UnderBridgeRoad
+)
+
+// Rail track bits, by the edges of the tile they connect
+const (
+	TrackX     = 1 << iota // north-east and south-west
+	TrackY                 // north-west and south-east
+	TrackUpper             // north-east and north-west
+	TrackLower             // south-west and south-east
+	TrackLeft              // south-west and north-west
+	TrackRight             // north-east and south-east
+)
+
 type Savegame struct {
 	Checksum                                           uint32 // Do not set, this is calculated automatically
 	Title                                              string
@@ -41,6 +222,9 @@ type Savegame struct {
 	TextEffects                                        []TextEffect
 	Seed                                               uint64
 	Towns                                              []Town
+	Industries                                         []Industry
+	Stations                                           []Station
+	Vehicles                                           []Vehicle
 	Schedules                                          []uint16
 	Animations                                         []uint16
 	Depots                                             []Depot
@@ -90,12 +274,40 @@ type Savegame struct {
 	CustomVehicleNames, CustomVehicleNamesCanBeChanged bool
 	SnowLine                                           uint8
 	Tiles                                              []Tile
+	TropicZones                                        []uint8 // sub-tropical: zone of each tile, nil for all normal
+	Unparsed                                           Unparsed
 }
 
-type InFile interface {
-	Read(b []byte) (int, error)
-}
+// Unparsed holds the regions of a loaded savegame that aren't decoded, so
+// that saving it again keeps them. Save writes defaults for the regions that
+// are nil.
+type Unparsed struct {
+	TownTails             []byte // the rest of each town slot after the name
+	SchedulesEnd          []byte
+	CostsAndCargo         []byte
+	Stations              []byte // the bytes of each station slot that aren't decoded
+	Industries            []byte // the bytes of each industry slot that aren't decoded
+	CompanyBodies         []byte // the rest of each company slot after the names
+	Vehicles              []byte // the bytes of each vehicle slot that aren't decoded
+	CustomStrings         []byte // including the ones that aren't names of towns, stations or companies
+	SignsAndVehicleTypes  []byte
+	Subsidies             []byte
+	TextPointers          []byte
+	AfterDate             []byte
+	IndustryAndCargoTypes []byte
 
-type OutFile interface {
-	Write(b []byte) (int, error)
+	// The tile layers, for the bits that aren't decoded. They are used for the
+	// tiles that still have the class they had when loaded, which also keeps
+	// tiles of unsupported classes.
+	L1, L2, L3, L4, L5 []byte
 }
+
+// InFile is what Load reads from.
+//
+// Deprecated: use io.Reader.
+type InFile = io.Reader
+
+// OutFile is what Save writes to.
+//
+// Deprecated: use io.Writer.
+type OutFile = io.Writer
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"osm2ttd/ttd"
+)
+
+// coastSegment is a piece of coastline between two coordinates, with the
+// land on the left side.
+type coastSegment struct {
+	lat1, lon1, lat2, lon2 float64
+}
+
+// coastSeeds returns points a bit less than a tile left and right of the
+// segment, sampled along its length. The ends aren't sampled, because at a
+// corner the point beside one segment can be on the wrong side of the next.
+func coastSeeds(c coastSegment) (land, sea []point) {
+	p1 := coordToPoint(c.lat1, c.lon1)
+	p2 := coordToPoint(c.lat2, c.lon2)
+	if max(p1.x, p2.x) < -1 || min(p1.x, p2.x) > 257 || max(p1.y, p2.y) < -1 || min(p1.y, p2.y) > 257 {
+		return nil, nil
+	}
+	// find the right side in map coordinates by projecting a point that is
+	// right of the middle of the segment in geographic coordinates
+	dLat, dLon := c.lat2-c.lat1, c.lon2-c.lon1
+	length := math.Hypot(dLat, dLon)
+	if length == 0 {
+		return nil, nil
+	}
+	midLat, midLon := (c.lat1+c.lat2)/2, (c.lon1+c.lon2)/2
+	mid := coordToPoint(midLat, midLon)
+	right := coordToPoint(midLat-dLon/length*1e-6, midLon+dLat/length*1e-6)
+	dx, dy := right.x-mid.x, right.y-mid.y
+	offset := 0.7 / math.Hypot(dx, dy)
+	dx, dy = dx*offset, dy*offset
+
+	steps := int(math.Hypot(p2.x-p1.x, p2.y-p1.y)*2) + 1
+	for i := range steps {
+		f := (float64(i) + 0.5) / float64(steps)
+		p := point{p1.x + (p2.x-p1.x)*f, p1.y + (p2.y-p1.y)*f}
+		land = append(land, point{p.x - dx, p.y - dy})
+		sea = append(sea, point{p.x + dx, p.y + dy})
+	}
+	return land, sea
+}
+
+// fillSea adds the rule's tiles on the sea side of the coastline. Each tile
+// gets the side of the closest coastline, by growing the land and sea sides
+// next to the coastline at the same time.
+func fillSea(l *layers, coast []coastSegment, r *rule) int {
+	const (
+		unknown = iota
+		land
+		sea
+	)
+	side := make([]uint8, ttd.NumberOfTiles)
+	var queue []int
+	seed := func(points []point, v uint8) {
+		for _, p := range points {
+			if p.x < 0 || p.y < 0 || p.x >= 256 || p.y >= 256 {
+				continue
+			}
+			i := xyToTile(int(p.x), int(p.y))
+			if side[i] == unknown {
+				side[i] = v
+				queue = append(queue, i)
+			}
+		}
+	}
+	for _, c := range coast {
+		// land first, so that tiles on both sides of a narrow spit stay land
+		l, _ := coastSeeds(c)
+		seed(l, land)
+	}
+	for _, c := range coast {
+		_, w := coastSeeds(c)
+		seed(w, sea)
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		x, y := i%256, i/256
+		for _, n := range [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+			if n[0] < 0 || n[1] < 0 || n[0] > 255 || n[1] > 255 {
+				continue
+			}
+			j := xyToTile(n[0], n[1])
+			if side[j] == unknown {
+				side[j] = side[i]
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	count := 0
+	for i, v := range side {
+		if v == sea {
+			l.set(r, i%256, i/256)
+			count++
+		}
+	}
+	return count
+}